@@ -18,18 +18,27 @@ public class GStreamerPipeline {
     private final Pipeline pipeline;
     private final Element videoUdpSrc;
     private final AppSink displaySink;
+    private final int ulpfecPayloadType;
+    private final int fecPort;
 
+    /**
+     * @param ulpfecPayloadType payload type of the sender's ULPFEC packets
+     * @param fecPort port of the ST 2022-1 column FEC stream, row FEC on the next port
+     */
     public GStreamerPipeline(AppSink displaySink, String videoCodec, int videoPort,
-                            String audioCodec, int audioPort) {
-        logger.info(String.format("Creating pipeline: Video=%s:%d, Audio=%s:%d",
-                                  videoCodec, videoPort, audioCodec, audioPort));
+                            String audioCodec, int audioPort, String fecMode,
+                            int ulpfecPayloadType, int fecPort) {
+        logger.info(String.format("Creating pipeline: Video=%s:%d, Audio=%s:%d, FEC=%s",
+                                  videoCodec, videoPort, audioCodec, audioPort, fecMode));
         this.displaySink = displaySink;
+        this.ulpfecPayloadType = ulpfecPayloadType;
+        this.fecPort = fecPort;
 
         // Log equivalent gst-launch description for debugging
-        logPipelineDescription(videoCodec, videoPort, audioCodec, audioPort);
+        logPipelineDescription(videoCodec, videoPort, audioCodec, audioPort, fecMode);
 
         // Build pipeline element-by-element
-        this.pipeline = buildPipeline(videoCodec, videoPort, audioCodec, audioPort);
+        this.pipeline = buildPipeline(videoCodec, videoPort, audioCodec, audioPort, fecMode);
         this.videoUdpSrc = pipeline.getElementByName("video_src");
 
         logger.info("Pipeline created successfully");
@@ -38,21 +47,21 @@ public class GStreamerPipeline {
     // --- Pipeline construction ---
 
     private Pipeline buildPipeline(String videoCodec, int videoPort,
-                                   String audioCodec, int audioPort) {
+                                   String audioCodec, int audioPort, String fecMode) {
         Pipeline p = new Pipeline();
 
-        buildVideoChain(p, videoCodec, videoPort);
+        buildVideoChain(p, videoCodec, videoPort, fecMode);
         buildAudioChain(p, audioCodec, audioPort);
 
         return p;
     }
 
-    private void buildVideoChain(Pipeline p, String videoCodec, int videoPort) {
+    private void buildVideoChain(Pipeline p, String videoCodec, int videoPort, String fecMode) {
         // Source
         Element src = ElementFactory.make("udpsrc", "video_src");
         src.set("port", videoPort);
         src.set("caps", new Caps(
-            "application/x-rtp, media=video, encoding-name=" + videoCodec));
+            "application/x-rtp, media=video, clock-rate=90000, encoding-name=" + videoCodec));
 
         // Queue
         Element queue = ElementFactory.make("queue", null);
@@ -77,6 +86,7 @@ public class GStreamerPipeline {
         List<Element> chain = new ArrayList<>();
         chain.add(src);
         chain.add(queue);
+        chain.addAll(buildFecDecoder(fecMode));
         chain.add(depay);
         if (parser != null) chain.add(parser);
         chain.add(decoder);
@@ -87,6 +97,55 @@ public class GStreamerPipeline {
             p.add(e);
         }
         linkChain(chain);
+        linkFecSources(p, fecMode);
+    }
+
+    /**
+     * Builds the FEC recovery elements placed before the depayloader.
+     * ULPFEC is recovered from the video stream itself, once the jitter buffer
+     * reports a packet lost, ST 2022-1 from the column/row FEC streams on the
+     * FEC port and the next one (see linkFecSources).
+     */
+    private List<Element> buildFecDecoder(String fecMode) {
+        switch (fecMode) {
+            case ReceiverSettings.FEC_ULPFEC: {
+                Element storage = ElementFactory.make("rtpstorage", "fec_storage");
+                storage.set("size-time", 250_000_000L);
+                Element jitterBuffer = ElementFactory.make("rtpjitterbuffer", null);
+                jitterBuffer.set("do-lost", true);
+                jitterBuffer.set("latency", 200);
+                Element dec = ElementFactory.make("rtpulpfecdec", "fec_dec");
+                dec.set("pt", ulpfecPayloadType);
+                dec.set("storage", storage.get("internal-storage"));
+                return List.of(storage, jitterBuffer, dec);
+            }
+            case ReceiverSettings.FEC_ST2022_1: {
+                Element dec = ElementFactory.make("rtpst2022-1-fecdec", "fec_dec");
+                return List.of(dec);
+            }
+            default:
+                return List.of();
+        }
+    }
+
+    /**
+     * Feeds the ST 2022-1 column/row FEC streams into the decoder.
+     * Must be called after the decoder has been added to the pipeline.
+     */
+    private void linkFecSources(Pipeline p, String fecMode) {
+        if (!ReceiverSettings.FEC_ST2022_1.equals(fecMode)) {
+            return;
+        }
+        Element dec = p.getElementByName("fec_dec");
+        for (int i = 0; i < 2; i++) {
+            Element fecSrc = ElementFactory.make("udpsrc", "fec_src_" + i);
+            fecSrc.set("port", fecPort + i);
+            fecSrc.set("caps", new Caps("application/x-rtp"));
+            p.add(fecSrc);
+            if (!fecSrc.linkPads("src", dec, "fec_%u")) {
+                logger.warning("Failed to link FEC source " + i);
+            }
+        }
     }
 
     private void buildAudioChain(Pipeline p, String audioCodec, int audioPort) {
@@ -212,7 +271,7 @@ public class GStreamerPipeline {
     // --- Logging ---
 
     private void logPipelineDescription(String videoCodec, int videoPort,
-                                        String audioCodec, int audioPort) {
+                                        String audioCodec, int audioPort, String fecMode) {
         String parserName = getParserName(videoCodec);
         String decoderName;
         try {
@@ -223,9 +282,16 @@ public class GStreamerPipeline {
 
         StringBuilder sb = new StringBuilder();
         sb.append(String.format(
-            "udpsrc name=video_src port=%d caps=\"application/x-rtp, media=video, encoding-name=%s\"",
+            "udpsrc name=video_src port=%d caps=\"application/x-rtp, media=video, clock-rate=90000, encoding-name=%s\"",
             videoPort, videoCodec));
         sb.append(" ! queue max-size-buffers=3");
+        if (ReceiverSettings.FEC_ULPFEC.equals(fecMode)) {
+            sb.append(" ! rtpstorage name=fec_storage size-time=250000000");
+            sb.append(" ! rtpjitterbuffer do-lost=true latency=200");
+            sb.append(" ! rtpulpfecdec pt=").append(ulpfecPayloadType).append(" storage=fec_storage.internal-storage");
+        } else if (ReceiverSettings.FEC_ST2022_1.equals(fecMode)) {
+            sb.append(" ! rtpst2022-1-fecdec name=fec_dec");
+        }
         sb.append(" ! ").append(getDepayloaderName(videoCodec));
         if (parserName != null) sb.append(" ! ").append(parserName);
         sb.append(" ! ").append(decoderName);
@@ -236,6 +302,12 @@ public class GStreamerPipeline {
             audioPort, audioCodec));
         sb.append(" ! queue max-size-buffers=1");
         sb.append(" ! rtpopusdepay ! opusdec ! audioconvert ! autoaudiosink sync=false");
+        if (ReceiverSettings.FEC_ST2022_1.equals(fecMode)) {
+            for (int i = 0; i < 2; i++) {
+                sb.append(String.format(" udpsrc port=%d caps=\"application/x-rtp\" ! fec_dec.fec_%d",
+                    fecPort + i, i));
+            }
+        }
 
         logger.info("Pipeline description:\n  " + sb.toString().replace(" ! ", "\n  ! "));
     }
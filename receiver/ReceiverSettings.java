@@ -1,7 +1,10 @@
+import java.io.IOException;
+import java.nio.file.Files;
+import java.nio.file.Path;
 import java.util.logging.Logger;
 
 /**
- * Holds receiver settings (codecs, ports) and notifies listeners on change.
+ * Holds receiver settings (codecs, ports, FEC) and notifies listeners on change.
  * No Swing or GStreamer dependencies.
  */
 public class ReceiverSettings {
@@ -17,12 +20,24 @@ public class ReceiverSettings {
     // Audio codecs
     public static final String CODEC_OPUS = "OPUS";
 
+    // Forward error correction (must match the sender's --fec option)
+    public static final String FEC_NONE = "NONE";
+    public static final String FEC_ULPFEC = "ULPFEC";
+    public static final String FEC_ST2022_1 = "ST2022-1";
+
+    // Sender defaults: ULPFEC payload type, ST 2022-1 column FEC on video port + 6 (row FEC + 7)
+    public static final int DEFAULT_ULPFEC_PAYLOAD_TYPE = 122;
+    public static final int ST2022_1_PORT_OFFSET = 6;
+
     public static final long UDP_TIMEOUT_NANOS = 3_000_000_000L;
 
     private String videoCodec = CODEC_H264;
     private int videoPort = 5000;
     private String audioCodec = CODEC_OPUS;
     private int audioPort = 5001;
+    private String fecMode = FEC_NONE;
+    private int ulpfecPayloadType = DEFAULT_ULPFEC_PAYLOAD_TYPE;
+    private int fecPort = 0; // 0 = video port + ST2022_1_PORT_OFFSET
     private boolean timeoutEnabled = false;
 
     private Runnable onSettingsChanged;
@@ -49,6 +64,19 @@ public class ReceiverSettings {
         return audioPort;
     }
 
+    public String getFecMode() {
+        return fecMode;
+    }
+
+    public int getUlpfecPayloadType() {
+        return ulpfecPayloadType;
+    }
+
+    /** Port of the ST 2022-1 column FEC stream, the row FEC stream is on the next port. */
+    public int getFecPort() {
+        return fecPort != 0 ? fecPort : videoPort + ST2022_1_PORT_OFFSET;
+    }
+
     public boolean isTimeoutEnabled() {
         return timeoutEnabled;
     }
@@ -93,15 +121,114 @@ public class ReceiverSettings {
         fireChanged();
     }
 
+    public void setFecMode(String mode) {
+        if (mode.equals(this.fecMode)) {
+            return;
+        }
+        logger.info("Switching FEC to: " + mode);
+        this.fecMode = mode;
+        this.timeoutEnabled = false;
+        fireChanged();
+    }
+
+    public void setUlpfecPayloadType(int pt) {
+        if (pt == this.ulpfecPayloadType) {
+            return;
+        }
+        logger.info("Switching ULPFEC payload type to: " + pt);
+        this.ulpfecPayloadType = pt;
+        fireChanged();
+    }
+
+    public void setFecPort(int port) {
+        if (port == this.fecPort) {
+            return;
+        }
+        logger.info("Switching FEC port to: " + port);
+        this.fecPort = port;
+        fireChanged();
+    }
+
     public void setTimeoutEnabled(boolean enabled) {
         this.timeoutEnabled = enabled;
     }
 
+    // --- Command line ---
+
+    /**
+     * Applies the command line options: --sdp FILE reads the video port and
+     * the ULPFEC payload type from the sender's SDP, --video-port, --fec-pt
+     * and --fec-port (ST 2022-1 column FEC, row FEC on the next port) set
+     * them directly. Later options override earlier ones.
+     */
+    public void applyArgs(String[] args) throws IOException {
+        for (int i = 0; i < args.length; i++) {
+            String arg = args[i];
+            if (i + 1 >= args.length) {
+                throw new IllegalArgumentException("Unknown or incomplete option: " + arg);
+            }
+            String value = args[++i];
+            switch (arg) {
+                case "--sdp":
+                    applySdp(Files.readString(Path.of(value)));
+                    break;
+                case "--video-port":
+                    videoPort = parsePort(arg, value);
+                    break;
+                case "--fec-pt":
+                    ulpfecPayloadType = parsePayloadType(arg, value);
+                    break;
+                case "--fec-port":
+                    fecPort = parsePort(arg, value);
+                    break;
+                default:
+                    throw new IllegalArgumentException("Unknown option: " + arg);
+            }
+        }
+    }
+
+    /**
+     * Reads the video port and the ULPFEC payload type from an SDP written by
+     * the sender. An a=rtpmap:PT ulpfec/90000 line also selects ULPFEC.
+     */
+    public void applySdp(String sdp) {
+        for (String line : sdp.split("\\r?\\n")) {
+            String[] fields = line.trim().split("\\s+");
+            if (line.startsWith("m=video ") && fields.length > 1) {
+                videoPort = parsePort("m=video", fields[1]);
+            } else if (line.startsWith("a=rtpmap:") && fields.length > 1
+                    && fields[1].toLowerCase().startsWith("ulpfec/")) {
+                ulpfecPayloadType = parsePayloadType("a=rtpmap", fields[0].substring("a=rtpmap:".length()));
+                fecMode = FEC_ULPFEC;
+            }
+        }
+    }
+
+    private static int parsePort(String option, String value) {
+        int port = Integer.parseInt(value.trim());
+        if (port <= 0 || port > 65535) {
+            throw new IllegalArgumentException("Invalid port for " + option + ": " + value);
+        }
+        return port;
+    }
+
+    private static int parsePayloadType(String option, String value) {
+        int pt = Integer.parseInt(value.trim());
+        if (pt < 96 || pt > 127) {
+            throw new IllegalArgumentException("Invalid payload type for " + option + ", expected 96-127: " + value);
+        }
+        return pt;
+    }
+
     // --- Status ---
 
     public String getStatusText() {
-        return "Video: " + videoCodec + "/" + videoPort +
-               "  Audio: " + audioCodec + "/" + audioPort;
+        String status = "Video: " + videoCodec + "/" + videoPort +
+                        "  Audio: " + audioCodec + "/" + audioPort;
+        if (!FEC_NONE.equals(fecMode)) {
+            status += "  FEC: " + fecMode;
+        }
+        return status;
     }
 
     private void fireChanged() {
@@ -80,7 +80,8 @@ public class ReceiverWindow {
     private void startPipeline() {
         pipeline = new GStreamerPipeline(displaySink,
                 settings.getVideoCodec(), settings.getVideoPort(),
-                settings.getAudioCodec(), settings.getAudioPort());
+                settings.getAudioCodec(), settings.getAudioPort(),
+                settings.getFecMode(), settings.getUlpfecPayloadType(), settings.getFecPort());
         pipeline.play();
         startAutoResize();
         attachBusHandlers();
@@ -119,8 +120,8 @@ public class ReceiverWindow {
         JMenu videoPortMenu = new JMenu("Video Port");
         ButtonGroup videoPortGroup = new ButtonGroup();
         Map<Integer, JRadioButtonMenuItem> videoPortItems = new HashMap<>();
-        addPortItem(videoPortMenu, videoPortGroup, videoPortItems, 5000, true, true);
-        addPortItem(videoPortMenu, videoPortGroup, videoPortItems, 6000, false, true);
+        addPortItem(videoPortMenu, videoPortGroup, videoPortItems, 5000, settings.getVideoPort() == 5000, true);
+        addPortItem(videoPortMenu, videoPortGroup, videoPortItems, 6000, settings.getVideoPort() == 6000, true);
         videoPortMenu.addSeparator();
         JMenuItem customVideoPort = new JMenuItem("Custom...");
         customVideoPort.addActionListener(e -> showCustomPortDialog(videoPortItems, true));
@@ -145,6 +146,15 @@ public class ReceiverWindow {
         audioPortMenu.add(customAudioPort);
         menuBar.add(audioPortMenu);
 
+        // FEC menu
+        JMenu fecMenu = new JMenu("FEC");
+        ButtonGroup fecGroup = new ButtonGroup();
+        String fecMode = settings.getFecMode();
+        addFecItem(fecMenu, fecGroup, ReceiverSettings.FEC_NONE, "None", ReceiverSettings.FEC_NONE.equals(fecMode));
+        addFecItem(fecMenu, fecGroup, ReceiverSettings.FEC_ULPFEC, "ULPFEC", ReceiverSettings.FEC_ULPFEC.equals(fecMode));
+        addFecItem(fecMenu, fecGroup, ReceiverSettings.FEC_ST2022_1, "SMPTE 2022-1", ReceiverSettings.FEC_ST2022_1.equals(fecMode));
+        menuBar.add(fecMenu);
+
         menuBar.add(Box.createHorizontalGlue());
         menuBar.add(statusLabel);
 
@@ -165,6 +175,13 @@ public class ReceiverWindow {
         menu.add(item);
     }
 
+    private void addFecItem(JMenu menu, ButtonGroup group, String mode, String label, boolean selected) {
+        JRadioButtonMenuItem item = new JRadioButtonMenuItem(label, selected);
+        item.addActionListener(e -> settings.setFecMode(mode));
+        group.add(item);
+        menu.add(item);
+    }
+
     private void addPortItem(JMenu menu, ButtonGroup group, Map<Integer, JRadioButtonMenuItem> portItems,
                              int port, boolean selected, boolean isVideo) {
         JRadioButtonMenuItem item = new JRadioButtonMenuItem(String.valueOf(port), selected);
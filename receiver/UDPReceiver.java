@@ -1,3 +1,4 @@
+import java.io.IOException;
 import java.nio.file.Files;
 import java.nio.file.Path;
 import java.nio.file.Paths;
@@ -31,7 +32,16 @@ public class UDPReceiver {
         String[] gstArgs = (gstDebug != null)
                 ? args
                 : mergeArgs(args, "--gst-debug-level=2");
-        Gst.init("FPV UDP Receiver", gstArgs);
+        String[] options = Gst.init("FPV UDP Receiver", gstArgs);
+
+        ReceiverSettings settings = new ReceiverSettings();
+        try {
+            settings.applyArgs(options);
+        } catch (IOException | IllegalArgumentException e) {
+            System.err.println("Error: " + e.getMessage());
+            System.err.println("Usage: udp [--sdp FILE] [--video-port PORT] [--fec-pt PT] [--fec-port PORT]");
+            System.exit(2);
+        }
 
         logger.info("FPV UDP Receiver starting...");
 
@@ -40,7 +50,6 @@ public class UDPReceiver {
             GstVideoComponent videoComponent = new GstVideoComponent(displaySink);
             videoComponent.setKeepAspect(true);
 
-            ReceiverSettings settings = new ReceiverSettings();
             ReceiverWindow window = new ReceiverWindow(videoComponent, displaySink, settings);
             window.start();
         });
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+)
+
+// Default adaptive bitrate range in kbps
+const (
+	defaultMinBitrate = 300
+	defaultMaxBitrate = 6000
+)
+
+// Loss-based rate control after the loss controller of Google Congestion
+// Control (draft-ietf-rmcat-gcc): back off in proportion to heavy loss, probe
+// upwards while the link is clean, hold in between
+const (
+	abrLossHigh    = 0.10                  // Decrease above this fraction lost
+	abrLossLow     = 0.02                  // Increase below this fraction lost
+	abrIncrease    = 1.05                  // Growth per clean receiver report
+	abrJitterLimit = 30 * time.Millisecond // Hold instead of increasing above this jitter
+	abrSwapPause   = 5 * time.Second       // Minimum time between encoder swaps
+
+	// Reports are collected for the RTCP report interval (RFC 3550 minimum)
+	// before a decision, so every receiver has reported once
+	abrReportInterval = 5 * time.Second
+)
+
+// receiverReport is the report block of an RTCP receiver report about the video
+type receiverReport struct {
+	SSRC         uint
+	FractionLost float64
+	Jitter       time.Duration
+	RoundTrip    time.Duration
+}
+
+func (r receiverReport) String() string {
+	return fmt.Sprintf("receiver %08x: loss %.1f%%, jitter %dms, rtt %dms",
+		r.SSRC, r.FractionLost*100, r.Jitter.Milliseconds(), r.RoundTrip.Milliseconds())
+}
+
+// abrTarget is the bitrate and quality ladder rung the encoder should run at
+type abrTarget struct {
+	Bitrate int // kbps
+	Rung    int // Index in StreamConfig.Ladder
+}
+
+// BitrateController adapts the video bitrate to the RTCP receiver reports of
+// the ground station, and with a quality ladder the resolution and framerate.
+// It decides once per report interval on the receiver with the highest loss,
+// so with several receivers the worst link sets the bitrate. Safe for
+// concurrent use.
+type BitrateController struct {
+	config   StreamConfig
+	pipeline *gst.Pipeline
+	targets  chan abrTarget
+	done     chan struct{}
+
+	mu        sync.Mutex
+	bitrate   int                     // Current target in kbps
+	rung      int                     // Current ladder rung
+	lastSeq   map[uint]int            // Extended highest sequence number of the last report per receiver
+	pending   map[uint]receiverReport // Worst report per receiver in the current interval
+	last      receiverReport
+	pressure  int // Consecutive reports calling for a lower rung
+	clean     int // Consecutive reports allowing a higher rung
+	increases int
+	decreases int
+	holds     int
+}
+
+// NewBitrateController creates a bitrate controller for a video pipeline,
+// starting at the maximum bitrate
+func NewBitrateController(config StreamConfig, pipeline *gst.Pipeline) *BitrateController {
+	return &BitrateController{
+		config:   config,
+		pipeline: pipeline,
+		targets:  make(chan abrTarget, 1),
+		done:     make(chan struct{}),
+		bitrate:  config.MaxBitrate,
+		lastSeq:  make(map[uint]int),
+		pending:  make(map[uint]receiverReport),
+	}
+}
+
+// Start sets the initial bitrate and follows the receiver reports. Call it
+// before the pipeline plays.
+func (c *BitrateController) Start() error {
+	encoder, err := c.pipeline.GetElementByName(videoEncoderName)
+	if err != nil {
+		return fmt.Errorf("failed to find video encoder: %w", err)
+	}
+	if err := setEncoderBitrate(encoder, c.config.Encoder, c.bitrate); err != nil {
+		return err
+	}
+	rtpbin, err := c.pipeline.GetElementByName(videoRTPBinName(c.config))
+	if err != nil {
+		return fmt.Errorf("failed to find video rtpbin: %w", err)
+	}
+
+	go c.apply()
+	go c.decideEvery(abrReportInterval)
+	// Receivers that left must not hold back the others
+	forget := func(self *gst.Element, sessionID uint, ssrc uint) {
+		if sessionID == videoSessionID {
+			c.forgetReceiver(ssrc)
+		}
+	}
+	rtpbin.Connect("on-ssrc-timeout", forget)
+	rtpbin.Connect("on-bye-ssrc", forget)
+	rtpbin.Connect("on-ssrc-active", func(self *gst.Element, sessionID uint, ssrc uint) {
+		if sessionID != videoSessionID {
+			return
+		}
+		stats := getSourceStats(self, sessionID, ssrc)
+		if stats == nil {
+			return
+		}
+		values := stats.Values()
+		if haveRB, _ := values["have-rb"].(bool); !haveRB {
+			return
+		}
+		fractionLost, _ := toInt(values["rb-fractionlost"])
+		seq, _ := toInt(values["rb-exthighestseq"])
+		jitter, _ := toInt(values["rb-jitter"])
+		roundTrip, _ := toInt(values["rb-round-trip"])
+
+		c.onReport(seq, receiverReport{
+			SSRC:         ssrc,
+			FractionLost: float64(fractionLost) / 256,
+			Jitter:       time.Duration(jitter) * time.Second / rtpClockRate,
+			RoundTrip:    time.Duration(roundTrip) * time.Second / 65536,
+		})
+	})
+	return nil
+}
+
+// Close stops applying bitrate changes
+func (c *BitrateController) Close() {
+	close(c.done)
+}
+
+// onReport collects a new receiver report for the next decision
+func (c *BitrateController) onReport(seq int, report receiverReport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// rtpbin signals every RTCP packet of the receiver, not only new reports
+	if last, ok := c.lastSeq[report.SSRC]; ok && last == seq {
+		return
+	}
+	c.lastSeq[report.SSRC] = seq
+	if pending, ok := c.pending[report.SSRC]; !ok || report.FractionLost >= pending.FractionLost {
+		c.pending[report.SSRC] = report
+	}
+}
+
+// forgetReceiver drops the state of a receiver that timed out or said BYE
+func (c *BitrateController) forgetReceiver(ssrc uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.lastSeq, ssrc)
+	delete(c.pending, ssrc)
+}
+
+// decideEvery makes a decision per interval until the controller is closed
+func (c *BitrateController) decideEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.decide()
+		}
+	}
+}
+
+// decide makes and logs one decision from the reports collected since the
+// last one. Intervals without reports leave the bitrate alone.
+func (c *BitrateController) decide() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.pending) == 0 {
+		return
+	}
+	report := worstReport(c.pending)
+	clear(c.pending)
+	c.last = report
+
+	target, reason := abrDecide(c.bitrate, report)
+	next := max(c.config.MinBitrate, min(c.config.MaxBitrate, target))
+	switch {
+	case next < c.bitrate:
+		c.decreases++
+		fmt.Printf("[abr] %s: decrease %d -> %d kbps (%s)\n", report, c.bitrate, next, reason)
+	case next > c.bitrate:
+		c.increases++
+		fmt.Printf("[abr] %s: increase %d -> %d kbps (%s)\n", report, c.bitrate, next, reason)
+	default:
+		c.holds++
+		if target != c.bitrate {
+			reason = "at the limit"
+		}
+		fmt.Printf("[abr] %s: hold %d kbps (%s)\n", report, c.bitrate, reason)
+	}
+	changed := next != c.bitrate
+	c.bitrate = next
+	if c.stepLadder(report) {
+		changed = true
+	}
+	if !changed {
+		return
+	}
+
+	// Only the latest target matters when the encoder lags behind
+	select {
+	case <-c.targets:
+	default:
+	}
+	c.targets <- abrTarget{Bitrate: c.bitrate, Rung: c.rung}
+}
+
+// worstReport returns the report with the highest loss, ties going to the
+// lowest SSRC. Its jitter is the highest of all, so a jittery link holds the
+// bitrate even when another one loses more.
+func worstReport(reports map[uint]receiverReport) receiverReport {
+	var worst receiverReport
+	var jitter time.Duration
+	first := true
+	for _, report := range reports {
+		jitter = max(jitter, report.Jitter)
+		if first || report.FractionLost > worst.FractionLost ||
+			(report.FractionLost == worst.FractionLost && report.SSRC < worst.SSRC) {
+			worst = report
+			first = false
+		}
+	}
+	worst.Jitter = jitter
+	return worst
+}
+
+// stepLadder moves down the quality ladder under sustained heavy loss or loss
+// at the bitrate floor, and back up after a run of clean decisions at the
+// maximum bitrate. Must be called with mu held.
+func (c *BitrateController) stepLadder(report receiverReport) bool {
+	ladder := c.config.Ladder
+	if len(ladder) == 0 {
+		return false
+	}
+	atFloor := c.bitrate == c.config.MinBitrate
+	if report.FractionLost > abrLossHigh || (atFloor && report.FractionLost >= abrLossLow) {
+		c.pressure++
+	} else {
+		c.pressure = 0
+	}
+	if report.FractionLost < abrLossLow && report.Jitter <= abrJitterLimit && c.bitrate == c.config.MaxBitrate {
+		c.clean++
+	} else {
+		c.clean = 0
+	}
+
+	switch {
+	case c.pressure >= ladderDownReports && c.rung < len(ladder)-1:
+		c.rung++
+		fmt.Printf("[abr] ladder down %s -> %s after %d lossy reports\n", ladder[c.rung-1], ladder[c.rung], c.pressure)
+	case c.clean >= ladderUpReports && c.rung > 0:
+		c.rung--
+		fmt.Printf("[abr] ladder up %s -> %s after %d clean reports at %d kbps\n", ladder[c.rung+1], ladder[c.rung], c.clean, c.bitrate)
+	default:
+		return false
+	}
+	c.pressure, c.clean = 0, 0
+	return true
+}
+
+// abrDecide returns the next bitrate for a receiver report and why
+func abrDecide(kbps int, report receiverReport) (int, string) {
+	switch {
+	case report.FractionLost > abrLossHigh:
+		return int(float64(kbps) * (1 - report.FractionLost/2)), fmt.Sprintf("loss above %.0f%%", abrLossHigh*100)
+	case report.FractionLost >= abrLossLow:
+		return kbps, fmt.Sprintf("loss between %.0f%% and %.0f%%", abrLossLow*100, abrLossHigh*100)
+	case report.Jitter > abrJitterLimit:
+		return kbps, fmt.Sprintf("jitter above %dms", abrJitterLimit.Milliseconds())
+	}
+	return int(float64(kbps) * abrIncrease), "clean link"
+}
+
+// apply changes the encoder bitrate and the ladder rung off the RTCP thread,
+// since swapping an encoder waits for the video streaming thread
+func (c *BitrateController) apply() {
+	live := encoderBitrates[c.config.Encoder].Live
+	applied := abrTarget{Bitrate: c.config.MaxBitrate}
+	for {
+		select {
+		case <-c.done:
+			return
+		case target := <-c.targets:
+			if target.Rung != applied.Rung {
+				rung := c.config.Ladder[target.Rung]
+				if err := setVideoRung(c.pipeline, c.config, rung); err != nil {
+					fmt.Printf("[abr] failed to switch to %s: %v\n", rung, err)
+					c.revertRung(target.Rung, applied.Rung)
+				} else {
+					applied.Rung = target.Rung
+				}
+			}
+			if target.Bitrate == applied.Bitrate {
+				continue
+			}
+			if err := changeVideoBitrate(c.pipeline, c.config.Encoder, target.Bitrate); err != nil {
+				fmt.Printf("[abr] failed to set %d kbps: %v\n", target.Bitrate, err)
+				c.revertBitrate(target.Bitrate, applied.Bitrate)
+				continue
+			}
+			applied.Bitrate = target.Bitrate
+			if live {
+				continue
+			}
+			// Every swap restarts the stream on a keyframe
+			select {
+			case <-c.done:
+				return
+			case <-time.After(abrSwapPause):
+			}
+		}
+	}
+}
+
+// revertRung goes back to the running rung after a failed switch, unless a
+// newer decision moved on meanwhile. The ladder retries after new pressure.
+func (c *BitrateController) revertRung(failed, running int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rung == failed {
+		c.rung = running
+		c.pressure, c.clean = 0, 0
+	}
+}
+
+// revertBitrate goes back to the running bitrate after a failed change,
+// unless a newer decision moved on meanwhile
+func (c *BitrateController) revertBitrate(failed, running int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.bitrate == failed {
+		c.bitrate = running
+	}
+}
+
+// Stats reports the current bitrate, the last report and the decisions taken
+func (c *BitrateController) Stats() map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := map[string]any{
+		"bitrate_kbps": c.bitrate,
+		"loss_pct":     fmt.Sprintf("%.1f", c.last.FractionLost*100),
+		"jitter_ms":    c.last.Jitter.Milliseconds(),
+		"increases":    c.increases,
+		"decreases":    c.decreases,
+		"holds":        c.holds,
+	}
+	if len(c.config.Ladder) > 0 {
+		stats["rung"] = c.config.Ladder[c.rung].String()
+	}
+	return stats
+}
+
+// videoRTPBinName returns the name of the rtpbin carrying the video
+func videoRTPBinName(config StreamConfig) string {
+	if config.SinglePipeline {
+		return "av-rtpbin"
+	}
+	return "video-rtpbin"
+}
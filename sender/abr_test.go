@@ -0,0 +1,215 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+var (
+	testRungHD60 = LadderRung{Resolution: Resolution{Name: "HD", Width: 1280, Height: 720}, Framerate: 60}
+	testRungHD30 = LadderRung{Resolution: Resolution{Name: "HD", Width: 1280, Height: 720}, Framerate: 30}
+	testRungVGA  = LadderRung{Resolution: Resolution{Name: "VGA", Width: 640, Height: 480}, Framerate: 30}
+)
+
+func newTestBitrateController(ladder ...LadderRung) *BitrateController {
+	return NewBitrateController(StreamConfig{MinBitrate: 300, MaxBitrate: 6000, Ladder: ladder}, nil)
+}
+
+// nextTarget returns the target queued for the encoder, if any
+func nextTarget(c *BitrateController) (abrTarget, bool) {
+	select {
+	case target := <-c.targets:
+		return target, true
+	default:
+		return abrTarget{}, false
+	}
+}
+
+func TestABRDecide(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		report receiverReport
+		want   int
+	}{
+		{"heavy loss", receiverReport{FractionLost: 0.2}, 900},
+		{"loss just above the limit", receiverReport{FractionLost: 0.11}, 945},
+		{"moderate loss", receiverReport{FractionLost: 0.05}, 1000},
+		{"loss at the low limit", receiverReport{FractionLost: abrLossLow}, 1000},
+		{"clean but jittery", receiverReport{FractionLost: 0.01, Jitter: 40 * time.Millisecond}, 1000},
+		{"clean", receiverReport{FractionLost: 0.01, Jitter: 10 * time.Millisecond}, 1050},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, reason := abrDecide(1000, tt.report); got != tt.want {
+				t.Errorf("abrDecide(1000, %s) = %d (%s), want %d", tt.report, got, reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestBitrateControllerWorstReceiverDecides(t *testing.T) {
+	c := newTestBitrateController()
+	c.onReport(100, receiverReport{SSRC: 0xA, FractionLost: 0, Jitter: 35 * time.Millisecond})
+	c.onReport(200, receiverReport{SSRC: 0xB, FractionLost: 0.2})
+	// Repeated RTCP packets carry the same report and are not counted again
+	c.onReport(100, receiverReport{SSRC: 0xA, FractionLost: 0.5})
+	c.decide()
+
+	target, ok := nextTarget(c)
+	if !ok || target.Bitrate != 5400 {
+		t.Fatalf("target = %+v (queued %v), want 5400 kbps from the 20%% loss of receiver B", target, ok)
+	}
+	if c.last.SSRC != 0xB || c.last.Jitter != 35*time.Millisecond {
+		t.Errorf("last report = %s, want receiver B with the highest jitter of all", c.last)
+	}
+
+	// No reports in an interval, no decision
+	c.decide()
+	if target, ok := nextTarget(c); ok {
+		t.Errorf("target %+v queued without reports", target)
+	}
+	if c.bitrate != 5400 || c.decreases != 1 || c.holds != 0 {
+		t.Errorf("bitrate %d after %d decreases and %d holds, want one decision only", c.bitrate, c.decreases, c.holds)
+	}
+}
+
+func TestBitrateControllerWorstReportPerReceiver(t *testing.T) {
+	c := newTestBitrateController()
+	// Two reports of one receiver in an interval, the lossy one counts
+	c.onReport(1, receiverReport{SSRC: 0xA, FractionLost: 0.3})
+	c.onReport(2, receiverReport{SSRC: 0xA, FractionLost: 0})
+	c.decide()
+
+	if target, ok := nextTarget(c); !ok || target.Bitrate != 5100 {
+		t.Errorf("target = %+v (queued %v), want 5100 kbps from the 30%% loss report", target, ok)
+	}
+}
+
+func TestBitrateControllerForgetReceiver(t *testing.T) {
+	c := newTestBitrateController()
+	c.onReport(1, receiverReport{SSRC: 0xA, FractionLost: 0.5})
+	c.forgetReceiver(0xA)
+	c.decide()
+	if target, ok := nextTarget(c); ok {
+		t.Errorf("target %+v queued from a receiver that left", target)
+	}
+	if _, ok := c.lastSeq[0xA]; ok {
+		t.Error("sequence number of a receiver that left is kept")
+	}
+
+	// A new receiver reusing the SSRC starts over
+	c.onReport(1, receiverReport{SSRC: 0xA, FractionLost: 0.2})
+	c.decide()
+	if target, ok := nextTarget(c); !ok || target.Bitrate != 5400 {
+		t.Errorf("target = %+v (queued %v), want 5400 kbps from the report of the new receiver", target, ok)
+	}
+}
+
+func TestBitrateControllerClampsToRange(t *testing.T) {
+	c := newTestBitrateController()
+	c.onReport(1, receiverReport{SSRC: 0xA, Jitter: time.Millisecond})
+	c.decide()
+	if target, ok := nextTarget(c); ok {
+		t.Errorf("target %+v queued above --max-bitrate", target)
+	}
+	if c.bitrate != 6000 || c.holds != 1 {
+		t.Errorf("bitrate %d with %d holds, want a hold at 6000", c.bitrate, c.holds)
+	}
+
+	for seq := 2; seq < 40; seq++ {
+		c.onReport(seq, receiverReport{SSRC: 0xA, FractionLost: 0.5})
+		c.decide()
+	}
+	if c.bitrate != 300 {
+		t.Errorf("bitrate = %d after heavy loss, want the 300 kbps floor", c.bitrate)
+	}
+}
+
+func TestStepLadder(t *testing.T) {
+	c := newTestBitrateController(testRungHD60, testRungHD30, testRungVGA)
+	lossy := receiverReport{FractionLost: 0.2}
+	clean := receiverReport{FractionLost: 0, Jitter: time.Millisecond}
+
+	// Down after ladderDownReports lossy decisions in a row
+	for i := 1; i < ladderDownReports; i++ {
+		if c.stepLadder(lossy) {
+			t.Fatalf("stepped down after %d lossy reports, want %d", i, ladderDownReports)
+		}
+	}
+	if !c.stepLadder(lossy) || c.rung != 1 {
+		t.Fatalf("rung = %d after %d lossy reports, want 1", c.rung, ladderDownReports)
+	}
+
+	// A clean report breaks the run
+	c.stepLadder(lossy)
+	c.stepLadder(clean)
+	c.stepLadder(lossy)
+	if c.rung != 1 {
+		t.Errorf("rung = %d, want 1 after an interrupted run", c.rung)
+	}
+
+	// At the floor, moderate loss is pressure too
+	c.pressure = 0
+	c.bitrate = c.config.MinBitrate
+	moderate := receiverReport{FractionLost: 0.05}
+	for range ladderDownReports {
+		c.stepLadder(moderate)
+	}
+	if c.rung != 2 {
+		t.Errorf("rung = %d after moderate loss at the floor, want 2", c.rung)
+	}
+	// The last rung stays
+	for range ladderDownReports {
+		c.stepLadder(lossy)
+	}
+	if c.rung != 2 {
+		t.Errorf("rung = %d, want the last rung 2", c.rung)
+	}
+
+	// Clean reports only count at the maximum bitrate
+	for range ladderUpReports {
+		c.stepLadder(clean)
+	}
+	if c.rung != 2 {
+		t.Errorf("rung = %d after clean reports below the maximum bitrate, want 2", c.rung)
+	}
+	c.bitrate = c.config.MaxBitrate
+	for i := 1; i < ladderUpReports; i++ {
+		c.stepLadder(clean)
+	}
+	if !c.stepLadder(clean) || c.rung != 1 {
+		t.Errorf("rung = %d after %d clean reports at the maximum bitrate, want 1", c.rung, ladderUpReports)
+	}
+}
+
+func TestBitrateControllerLadderTarget(t *testing.T) {
+	c := newTestBitrateController(testRungHD60, testRungHD30, testRungVGA)
+	var target abrTarget
+	for seq := range ladderDownReports {
+		c.onReport(seq, receiverReport{SSRC: 0xA, FractionLost: 0.2})
+		c.decide()
+		target, _ = nextTarget(c)
+	}
+	if target.Rung != 1 || c.rung != 1 {
+		t.Errorf("target = %+v with rung %d, want rung 1 queued after %d lossy intervals", target, c.rung, ladderDownReports)
+	}
+}
+
+func TestBitrateControllerRevert(t *testing.T) {
+	c := newTestBitrateController(testRungHD60, testRungHD30, testRungVGA)
+	c.rung, c.bitrate, c.pressure = 1, 2000, 2
+
+	// A failed switch goes back to the running rung
+	c.revertRung(1, 0)
+	c.revertBitrate(2000, 2500)
+	if c.rung != 0 || c.bitrate != 2500 || c.pressure != 0 {
+		t.Errorf("rung %d bitrate %d pressure %d, want the running rung 0 at 2500 kbps and no pressure", c.rung, c.bitrate, c.pressure)
+	}
+
+	// A newer decision is kept
+	c.rung, c.bitrate = 2, 1800
+	c.revertRung(1, 0)
+	c.revertBitrate(2000, 2500)
+	if c.rung != 2 || c.bitrate != 1800 {
+		t.Errorf("rung %d bitrate %d, want the newer rung 2 at 1800 kbps", c.rung, c.bitrate)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// resolveTimeout bounds a single hostname lookup
+const resolveTimeout = 5 * time.Second
+
+// isIPLiteral reports whether host is an IPv4/IPv6 address (optionally with a zone ID)
+func isIPLiteral(host string) bool {
+	_, err := netip.ParseAddr(host)
+	return err == nil
+}
+
+// resolveHost resolves a hostname (including mDNS .local names) to an IP address
+// string that can be passed to udpsink. IP literals are returned unchanged.
+func resolveHost(host string) (string, error) {
+	if isIPLiteral(host) {
+		return host, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("no addresses found for %s", host)
+	}
+
+	// Prefer IPv4, since most FPV ground stations listen on IPv4 only
+	selected := addrs[0]
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			selected = addr
+			break
+		}
+	}
+	return selected.String(), nil
+}
+
+// watchHostResolution re-resolves host every interval and calls onChange whenever
+// the resolved address differs from the current one. Lookup failures are logged
+// and the previous address is kept. The returned function stops the watcher.
+func watchHostResolution(host, current string, interval time.Duration, onChange func(addr string)) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				addr, err := resolveHost(host)
+				if err != nil {
+					fmt.Printf("WARNING: %v (keeping %s)\n", err, current)
+					continue
+				}
+				if addr != current {
+					fmt.Printf("Resolved %s: %s -> %s\n", host, current, addr)
+					current = addr
+					onChange(addr)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-gst/go-gst/gst"
+)
+
+// AudioCodec represents the audio encoding
+type AudioCodec string
+
+// Supported audio codecs
+const (
+	AudioOpus AudioCodec = "opus"
+	AudioPCMU AudioCodec = "pcmu"
+	AudioPCMA AudioCodec = "pcma"
+	AudioL16  AudioCodec = "l16"
+	AudioAAC  AudioCodec = "aac"
+)
+
+// Audio defaults
+const (
+	defaultAudioBitrate  = 128000
+	defaultOpusFrameSize = 20
+)
+
+// audioCodecInfo describes how an audio codec is encoded and payloaded
+type audioCodecInfo struct {
+	Name         string // Display name
+	Encoder      string // Encoder element, "" for raw PCM or AAC (see createAACEncoder)
+	Payloader    string // RTP payloader element
+	EncodingName string // RTP encoding name (SDP rtpmap, RTP caps)
+	Rate         int    // Default sample rate
+	Channels     int    // Default channel count
+	FixedFormat  bool   // Rate and channels are fixed by the codec
+	MinBitrate   int    // Bitrate range in bits/s, 0 when not adjustable
+	MaxBitrate   int
+}
+
+// audioCodecs lists the supported audio codecs, in --list order
+var audioCodecs = []AudioCodec{AudioOpus, AudioAAC, AudioPCMU, AudioPCMA, AudioL16}
+
+var audioCodecInfos = map[AudioCodec]audioCodecInfo{
+	AudioOpus: {Name: "Opus", Encoder: "opusenc", Payloader: "rtpopuspay", EncodingName: "OPUS",
+		Rate: 48000, Channels: 2, MinBitrate: 6000, MaxBitrate: 510000},
+	AudioAAC: {Name: "AAC", Payloader: "rtpmp4gpay", EncodingName: "MPEG4-GENERIC",
+		Rate: 48000, Channels: 2, MinBitrate: 8000, MaxBitrate: 320000},
+	AudioPCMU: {Name: "G.711 μ-law", Encoder: "mulawenc", Payloader: "rtppcmupay", EncodingName: "PCMU",
+		Rate: 8000, Channels: 1, FixedFormat: true},
+	AudioPCMA: {Name: "G.711 A-law", Encoder: "alawenc", Payloader: "rtppcmapay", EncodingName: "PCMA",
+		Rate: 8000, Channels: 1, FixedFormat: true},
+	AudioL16: {Name: "L16 PCM", Payloader: "rtpL16pay", EncodingName: "L16",
+		Rate: 48000, Channels: 2},
+}
+
+// opusRates are the sample rates opusenc accepts
+var opusRates = []int{8000, 12000, 16000, 24000, 48000}
+
+// opusFrameSizes are the opusenc frame sizes in ms
+var opusFrameSizes = []int{5, 10, 20, 40, 60}
+
+// aacRates are the sample rates of the MPEG-4 sampling frequency index
+var aacRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// AudioSettings holds the validated audio codec parameters
+type AudioSettings struct {
+	Codec     AudioCodec
+	Bitrate   int // bits/s, 0 for codecs without a bitrate
+	Rate      int
+	Channels  int
+	FrameSize int // Opus frame size in ms
+}
+
+// ValidateAudio checks an audio codec and its parameters. Zero values select
+// the codec defaults.
+func ValidateAudio(codec string, bitrate, rate, channels, frameSize int) (AudioSettings, error) {
+	c := AudioCodec(strings.ToLower(codec))
+	info, ok := audioCodecInfos[c]
+	if !ok {
+		return AudioSettings{}, fmt.Errorf("unsupported audio codec: %s\n\n%s", codec, ListAudioCodecs())
+	}
+	settings := AudioSettings{Codec: c, Rate: info.Rate, Channels: info.Channels}
+
+	if info.FixedFormat {
+		if (rate != 0 && rate != info.Rate) || (channels != 0 && channels != info.Channels) {
+			return AudioSettings{}, fmt.Errorf("%s is always %dHz, %dch", info.Name, info.Rate, info.Channels)
+		}
+	} else {
+		if rate != 0 {
+			settings.Rate = rate
+		}
+		if channels != 0 {
+			settings.Channels = channels
+		}
+	}
+	if settings.Channels < 1 || settings.Channels > 2 {
+		return AudioSettings{}, fmt.Errorf("audio channels must be 1 or 2, got: %d", settings.Channels)
+	}
+	switch c {
+	case AudioOpus:
+		if !containsInt(opusRates, settings.Rate) {
+			return AudioSettings{}, fmt.Errorf("Opus sample rate must be one of %v, got: %d", opusRates, settings.Rate)
+		}
+	case AudioAAC:
+		if !containsInt(aacRates, settings.Rate) {
+			return AudioSettings{}, fmt.Errorf("AAC sample rate must be one of %v, got: %d", aacRates, settings.Rate)
+		}
+	case AudioL16:
+		if settings.Rate < 8000 || settings.Rate > 192000 {
+			return AudioSettings{}, fmt.Errorf("L16 sample rate must be between 8000 and 192000, got: %d", settings.Rate)
+		}
+	}
+
+	if info.MaxBitrate == 0 {
+		if bitrate != 0 {
+			return AudioSettings{}, fmt.Errorf("%s has no adjustable bitrate", info.Name)
+		}
+	} else {
+		settings.Bitrate = defaultAudioBitrate
+		if bitrate != 0 {
+			settings.Bitrate = bitrate
+		}
+		if settings.Bitrate < info.MinBitrate || settings.Bitrate > info.MaxBitrate {
+			return AudioSettings{}, fmt.Errorf("%s bitrate must be between %d and %d, got: %d", info.Name, info.MinBitrate, info.MaxBitrate, settings.Bitrate)
+		}
+	}
+
+	if c == AudioOpus {
+		settings.FrameSize = defaultOpusFrameSize
+		if frameSize != 0 {
+			settings.FrameSize = frameSize
+		}
+		if !containsInt(opusFrameSizes, settings.FrameSize) {
+			return AudioSettings{}, fmt.Errorf("Opus frame size must be one of %v ms, got: %d", opusFrameSizes, settings.FrameSize)
+		}
+	} else if frameSize != 0 {
+		return AudioSettings{}, fmt.Errorf("--opus-frame-size only applies to Opus")
+	}
+
+	return settings, nil
+}
+
+// containsInt reports whether values contains v
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ListAudioCodecs returns the supported audio codecs with their elements and defaults
+func ListAudioCodecs() string {
+	var sb strings.Builder
+	sb.WriteString("Supported audio codecs (--audio-codec):\n\n")
+	for _, c := range audioCodecs {
+		info := audioCodecInfos[c]
+		encoder := info.Encoder
+		switch c {
+		case AudioAAC:
+			encoder = "fdkaacenc/avenc_aac"
+		case AudioL16:
+			encoder = "raw"
+		}
+		format := fmt.Sprintf("%dHz, %dch", info.Rate, info.Channels)
+		if info.FixedFormat {
+			format += " fixed"
+		}
+		sb.WriteString(fmt.Sprintf("  - %-5s %s (%s + %s, %s)\n", c, info.Name, encoder, info.Payloader, format))
+	}
+	return sb.String()
+}
+
+// describeAudio returns a short description such as "Opus, 48000Hz, 2ch, 128 kbps"
+func describeAudio(audio AudioSettings) string {
+	desc := fmt.Sprintf("%s, %dHz, %dch", audioCodecInfos[audio.Codec].Name, audio.Rate, audio.Channels)
+	if audio.Bitrate > 0 {
+		desc += fmt.Sprintf(", %d kbps", audio.Bitrate/1000)
+	}
+	return desc
+}
+
+// audioPayloadType returns the RTP payload type of the audio stream: the
+// static type of G.711, the dynamic default otherwise
+func audioPayloadType(audio AudioSettings) int {
+	switch audio.Codec {
+	case AudioPCMU:
+		return 0
+	case AudioPCMA:
+		return 8
+	}
+	return defaultAudioPayloadType
+}
+
+// audioClockRate returns the RTP clock rate of the audio stream. Opus always
+// uses 48kHz (RFC 7587), the others the sample rate.
+func audioClockRate(audio AudioSettings) int {
+	if audio.Codec == AudioOpus {
+		return opusClockRate
+	}
+	return audio.Rate
+}
+
+// audioRawCaps returns the raw audio format fed to the encoder
+func audioRawCaps(audio AudioSettings) string {
+	return fmt.Sprintf("audio/x-raw,rate=%d,channels=%d", audio.Rate, audio.Channels)
+}
+
+// createAudioEncoder creates the encoder elements of a codec, none for L16
+func createAudioEncoder(audio AudioSettings) ([]*gst.Element, error) {
+	switch audio.Codec {
+	case AudioL16:
+		return nil, nil
+	case AudioAAC:
+		aac, err := createAACEncoder(audio.Bitrate)
+		if err != nil {
+			return nil, err
+		}
+		aacParse, _ := gst.NewElement("aacparse")
+		return []*gst.Element{aac, aacParse}, nil
+	}
+
+	info := audioCodecInfos[audio.Codec]
+	enc, err := gst.NewElement(info.Encoder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create audio encoder %s: %w", info.Encoder, err)
+	}
+	if audio.Codec == AudioOpus {
+		enc.SetProperty("bitrate", audio.Bitrate)
+		enc.SetArg("frame-size", fmt.Sprint(audio.FrameSize))
+	}
+	return []*gst.Element{enc}, nil
+}
+
+// audioEncoderCommand returns the gst-launch elements of the encoder
+func audioEncoderCommand(audio AudioSettings) []string {
+	switch audio.Codec {
+	case AudioOpus:
+		return []string{fmt.Sprintf("opusenc bitrate=%d frame-size=%d", audio.Bitrate, audio.FrameSize)}
+	case AudioAAC:
+		return []string{fmt.Sprintf("%s bitrate=%d", aacEncoderCommand(), audio.Bitrate), "aacparse"}
+	case AudioL16:
+		return nil
+	}
+	return []string{audioCodecInfos[audio.Codec].Encoder}
+}
+
+// createAudioPayloader creates the RTP payloader of the audio stream
+func createAudioPayloader(config StreamConfig) (*gst.Element, error) {
+	name := audioCodecInfos[config.Audio.Codec].Payloader
+	payloader, err := gst.NewElement(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create audio payloader %s: %w", name, err)
+	}
+	configurePayloader(payloader, audioSSRC(config), audioPayloadType(config.Audio))
+	return payloader, nil
+}
+
+// audioPayloaderCommand returns the gst-launch payloader element of the audio stream
+func audioPayloaderCommand(config StreamConfig) string {
+	return audioCodecInfos[config.Audio.Codec].Payloader + payloaderCommandProps(audioSSRC(config), audioPayloadType(config.Audio))
+}
+
+// sdpAudioFormat returns the a=rtpmap encoding and a=fmtp parameters of the audio stream
+func sdpAudioFormat(audio AudioSettings) (string, string) {
+	switch audio.Codec {
+	case AudioOpus:
+		// Opus is always signalled as 2 channels, sprop-stereo tells the actual count
+		if audio.Channels == 2 {
+			return fmt.Sprintf("opus/%d/2", opusClockRate), "sprop-stereo=1"
+		}
+		return fmt.Sprintf("opus/%d/2", opusClockRate), ""
+	case AudioPCMU, AudioPCMA:
+		return fmt.Sprintf("%s/%d", audioCodecInfos[audio.Codec].EncodingName, audio.Rate), ""
+	case AudioL16:
+		return fmt.Sprintf("L16/%d/%d", audio.Rate, audio.Channels), ""
+	case AudioAAC:
+		return fmt.Sprintf("mpeg4-generic/%d/%d", audio.Rate, audio.Channels),
+			fmt.Sprintf("streamtype=5;profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=%s", aacAudioSpecificConfig(audio))
+	}
+	return "", ""
+}
+
+// aacAudioSpecificConfig returns the hex AudioSpecificConfig of AAC-LC at the
+// configured rate and channels (ISO 14496-3): object type, frequency index, channels
+func aacAudioSpecificConfig(audio AudioSettings) string {
+	freqIndex := 0
+	for i, rate := range aacRates {
+		if rate == audio.Rate {
+			freqIndex = i
+		}
+	}
+	const aacLC = 2
+	return fmt.Sprintf("%04x", aacLC<<11|freqIndex<<7|audio.Channels<<3)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/go-gst/go-gst/gst"
+)
+
+// encoderBitrate is the bitrate property of an encoder, its unit and its type.
+// GObject properties only accept values of their exact type.
+type encoderBitrate struct {
+	Property string
+	Scale    int  // Bits per second per property unit: 1000 for kbps, 1 for bps
+	Signed   bool // gint instead of guint
+	Live     bool // Applied while playing, otherwise the encoder is swapped
+}
+
+// encoderBitrates lists the target bitrate property of each encoder and
+// whether the encoder picks up a change while running
+var encoderBitrates = map[EncoderType]encoderBitrate{
+	// H.264
+	VTEncH264HW:   {"bitrate", 1000, false, true},
+	AMFH264Enc:    {"bitrate", 1000, false, false},
+	NVH264Enc:     {"bitrate", 1000, false, true},
+	NVV4L2H264Enc: {"bitrate", 1, false, true},
+	VAH264Enc:     {"bitrate", 1000, false, true},
+	VAH264LPEnc:   {"bitrate", 1000, false, true},
+	OpenH264Enc:   {"bitrate", 1, false, true},
+	X264Enc:       {"bitrate", 1000, false, true},
+	MPPH264Enc:    {"bps", 1, false, true},
+
+	// H.265
+	VTEncH265HW:   {"bitrate", 1000, false, true},
+	AMFH265Enc:    {"bitrate", 1000, false, false},
+	NVH265Enc:     {"bitrate", 1000, false, true},
+	NVV4L2H265Enc: {"bitrate", 1, false, true},
+	VAH265Enc:     {"bitrate", 1000, false, true},
+	VAH265LPEnc:   {"bitrate", 1000, false, true},
+	X265Enc:       {"bitrate", 1000, false, false},
+	MPPH265Enc:    {"bps", 1, false, true},
+
+	// VP8
+	VP8Enc:       {"target-bitrate", 1, true, true},
+	NVV4L2VP8Enc: {"bitrate", 1, false, true},
+	MPPVP8Enc:    {"bps", 1, false, true},
+
+	// VP9
+	VP9Enc:       {"target-bitrate", 1, true, true},
+	NVV4L2VP9Enc: {"bitrate", 1, false, true},
+
+	// AV1
+	SVTAV1Enc: {"target-bitrate", 1000, false, true},
+	AMFAV1Enc: {"bitrate", 1000, false, false},
+	NVAV1Enc:  {"bitrate", 1000, false, true},
+	VAAV1Enc:  {"bitrate", 1000, false, true},
+}
+
+// videoBitrateMu serializes bitrate changes of the video encoder
+var videoBitrateMu sync.Mutex
+
+// Bounds of a requested video bitrate
+const (
+	minVideoBitrate = 100    // kbps
+	maxVideoBitrate = 100000 // kbps
+)
+
+// ValidateVideoBitrate checks a video bitrate in kbps
+func ValidateVideoBitrate(kbps int) error {
+	if kbps < minVideoBitrate || kbps > maxVideoBitrate {
+		return fmt.Errorf("video bitrate must be between %d and %d kbps, got: %d", minVideoBitrate, maxVideoBitrate, kbps)
+	}
+	return nil
+}
+
+// setEncoderBitrate sets the target bitrate of a running encoder in kbps
+func setEncoderBitrate(encoder *gst.Element, encoderType EncoderType, kbps int) error {
+	bitrate, ok := encoderBitrates[encoderType]
+	if !ok {
+		return fmt.Errorf("%s has no known bitrate property", encoderType)
+	}
+	value := kbps * 1000 / bitrate.Scale
+	if bitrate.Signed {
+		return encoder.SetProperty(bitrate.Property, value)
+	}
+	return encoder.SetProperty(bitrate.Property, uint(value))
+}
+
+// changeVideoBitrate changes the target bitrate of the running video encoder
+// in kbps. Encoders that only read their bitrate on start are swapped for a
+// new one while the source and the sinks keep running; the swap drops the
+// frames inside the old encoder and restarts on a keyframe. Blocks until the
+// change is applied, so must not run on the main loop.
+func changeVideoBitrate(pipeline *gst.Pipeline, encoderType EncoderType, kbps int) error {
+	// The control API and the adaptive bitrate controller change the bitrate
+	// from their own goroutines, and two swaps must not race for the encoder
+	videoBitrateMu.Lock()
+	defer videoBitrateMu.Unlock()
+
+	bitrate, ok := encoderBitrates[encoderType]
+	if !ok {
+		return fmt.Errorf("%s has no known bitrate property", encoderType)
+	}
+	encoder, err := pipeline.GetElementByName(videoEncoderName)
+	if err != nil {
+		return fmt.Errorf("failed to find video encoder: %w", err)
+	}
+	if bitrate.Live {
+		return setEncoderBitrate(encoder, encoderType, kbps)
+	}
+	fmt.Printf("[bitrate] %s cannot change its bitrate while running, swapping the encoder\n", encoderType)
+	return swapVideoEncoder(pipeline, encoder, encoderType, kbps)
+}
+
+// swapVideoEncoder replaces the running video encoder by a new one with the
+// given bitrate. The swap happens in an idle probe on the pad feeding the
+// encoder, between two buffers, so no other element changes state.
+func swapVideoEncoder(pipeline *gst.Pipeline, old *gst.Element, encoderType EncoderType, kbps int) error {
+	upstream := old.GetStaticPad("sink").GetPeer()
+	downstream := old.GetStaticPad("src").GetPeer()
+	if upstream == nil || downstream == nil {
+		return fmt.Errorf("video encoder is not linked")
+	}
+
+	encoder, err := createVideoEncoder(encoderType, videoEncoderName)
+	if err != nil {
+		return err
+	}
+	if err := setEncoderBitrate(encoder, encoderType, kbps); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	upstream.AddProbe(gst.PadProbeTypeIdle, func(*gst.Pad, *gst.PadProbeInfo) gst.PadProbeReturn {
+		done <- relinkVideoEncoder(pipeline, old, encoder, upstream, downstream)
+		return gst.PadProbeRemove
+	})
+	return <-done
+}
+
+// relinkVideoEncoder stops and removes the old encoder and links the new one
+// in its place. Runs while the upstream pad is idle.
+func relinkVideoEncoder(pipeline *gst.Pipeline, old, encoder *gst.Element, upstream, downstream *gst.Pad) error {
+	if err := old.BlockSetState(gst.StateNull); err != nil {
+		return fmt.Errorf("failed to stop the old encoder: %w", err)
+	}
+	upstream.Unlink(old.GetStaticPad("sink"))
+	old.GetStaticPad("src").Unlink(downstream)
+	pipeline.Remove(old)
+
+	// The new encoder takes the name of the old one, so it must be added after
+	pipeline.Add(encoder)
+	if ret := upstream.Link(encoder.GetStaticPad("sink")); ret != gst.PadLinkOK {
+		return fmt.Errorf("failed to link the new encoder input: %s", ret)
+	}
+	if ret := encoder.GetStaticPad("src").Link(downstream); ret != gst.PadLinkOK {
+		return fmt.Errorf("failed to link the new encoder output: %s", ret)
+	}
+	if !encoder.SyncStateWithParent() {
+		return fmt.Errorf("failed to start the new encoder")
+	}
+	return nil
+}
+
+// getEncoderBitrate returns the target bitrate of an encoder in kbps
+func getEncoderBitrate(encoder *gst.Element, encoderType EncoderType) (int, error) {
+	bitrate, ok := encoderBitrates[encoderType]
+	if !ok {
+		return 0, fmt.Errorf("%s has no known bitrate property", encoderType)
+	}
+	value, err := encoder.GetProperty(bitrate.Property)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := toInt(value)
+	if !ok {
+		return 0, fmt.Errorf("unexpected %s value %v", bitrate.Property, value)
+	}
+	return n * bitrate.Scale / 1000, nil
+}
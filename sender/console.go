@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// runConsole reads runtime commands from r (normally stdin) until EOF.
+//
+// Supported commands:
+//
+//	add host:port     Start streaming to another destination
+//	remove host:port  Stop streaming to a destination
+//	list              Show current destinations
+func runConsole(r io.Reader, dests *DestinationSet) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "add", "remove":
+			if len(fields) != 2 {
+				fmt.Printf("usage: %s host:port\n", fields[0])
+				continue
+			}
+			d, err := dests.Parse(fields[1])
+			if err != nil {
+				fmt.Printf("invalid destination: %v\n", err)
+				continue
+			}
+			if fields[0] == "add" {
+				err = dests.Add(d)
+			} else {
+				err = dests.Remove(d)
+			}
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Printf("%s %s (video %d, audio %d)\n", fields[0], d, d.Port, d.AudioPort)
+		case "list":
+			for _, d := range dests.List() {
+				fmt.Printf("  %s -> %s (video %d, audio %d)\n", d, d.Host, d.Port, d.AudioPort)
+			}
+		default:
+			fmt.Println("commands: add host:port, remove host:port, list")
+		}
+	}
+}
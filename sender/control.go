@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/go-gst/go-glib/glib"
+	"github.com/go-gst/go-gst/gst"
+)
+
+// controlMaxBody bounds the JSON body of a control request
+const controlMaxBody = 64 * 1024
+
+// ValidateControlAddress checks a control API listen address such as 127.0.0.1:8081
+func ValidateControlAddress(addr string) error {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("invalid control address %q, expected host:port", addr)
+	}
+	return nil
+}
+
+// controlStatus is the GET /status response
+type controlStatus struct {
+	Encoder      EncoderType               `json:"encoder"`
+	Resolution   string                    `json:"resolution"`
+	Width        int                       `json:"width"`
+	Height       int                       `json:"height"`
+	Framerate    int                       `json:"framerate"`
+	Bitrate      int                       `json:"bitrate_kbps,omitempty"`
+	Source       map[string]string         `json:"source"`
+	Paused       bool                      `json:"paused"`
+	Destinations []string                  `json:"destinations"`
+	Pipelines    map[string]string         `json:"pipelines"`
+	Stats        map[string]map[string]any `json:"stats"`
+}
+
+// ControlServer serves a JSON API changing the running pipelines. Handlers
+// run on HTTP goroutines and apply every change on the GLib main loop.
+type ControlServer struct {
+	config        StreamConfig
+	videoPipeline *gst.Pipeline
+	audioPipeline *gst.Pipeline
+	pipelines     []*gst.Pipeline
+	dests         *DestinationSet
+	stats         *StatsReporter
+	keyframes     *KeyframeRequester
+	listener      net.Listener
+
+	mu        sync.Mutex
+	paused    bool
+	videoTest bool
+	audioTest bool
+}
+
+// NewControlServer creates a control server for the running pipelines. The
+// video or audio pipeline and the keyframe requester are nil without video.
+func NewControlServer(config StreamConfig, videoPipeline, audioPipeline *gst.Pipeline, pipelines []*gst.Pipeline, dests *DestinationSet, stats *StatsReporter, keyframes *KeyframeRequester) *ControlServer {
+	return &ControlServer{
+		config:        config,
+		videoPipeline: videoPipeline,
+		audioPipeline: audioPipeline,
+		pipelines:     pipelines,
+		dests:         dests,
+		stats:         stats,
+		keyframes:     keyframes,
+		videoTest:     config.UseVideoTestSrc,
+		audioTest:     config.UseAudioTestSrc,
+	}
+}
+
+// Start listens for control requests in the background
+func (s *ControlServer) Start() error {
+	listener, err := net.Listen("tcp", s.config.ControlListen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.config.ControlListen, err)
+	}
+	s.listener = listener
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", s.handleStatus)
+	mux.HandleFunc("POST /bitrate", s.handleBitrate)
+	mux.HandleFunc("POST /keyframe", s.handleKeyframe)
+	mux.HandleFunc("POST /restart", s.handleRestart)
+	mux.HandleFunc("POST /pause", s.handlePause)
+	mux.HandleFunc("POST /destination", s.handleDestination)
+	mux.HandleFunc("POST /source", s.handleSource)
+	go http.Serve(listener, mux)
+
+	fmt.Printf("Control API at http://%s\n", listener.Addr())
+	return nil
+}
+
+// Close stops serving control requests
+func (s *ControlServer) Close() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+}
+
+func (s *ControlServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	var status controlStatus
+	runOnMainLoop(func() error {
+		status = s.status()
+		return nil
+	})
+	writeJSON(w, http.StatusOK, status)
+}
+
+// status collects the status. Must run on the main loop.
+func (s *ControlServer) status() controlStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := controlStatus{
+		Encoder:    s.config.Encoder,
+		Resolution: s.config.Resolution.Name,
+		Width:      s.config.Resolution.Width,
+		Height:     s.config.Resolution.Height,
+		Framerate:  s.config.Framerate,
+		Source:     map[string]string{},
+		Paused:     s.paused,
+		Pipelines:  map[string]string{},
+		Stats:      s.stats.Collect(),
+	}
+	if s.videoPipeline != nil {
+		status.Source["video"] = describeSource(s.videoTest)
+		if encoder, err := s.videoPipeline.GetElementByName(videoEncoderName); err == nil {
+			status.Bitrate, _ = getEncoderBitrate(encoder, s.config.Encoder)
+		}
+	}
+	if s.audioPipeline != nil {
+		status.Source["audio"] = describeSource(s.audioTest)
+	}
+	for _, d := range s.dests.List() {
+		status.Destinations = append(status.Destinations, d.String())
+	}
+	for _, pipeline := range s.pipelines {
+		status.Pipelines[pipeline.GetName()] = pipeline.GetCurrentState().String()
+	}
+	return status
+}
+
+func (s *ControlServer) handleBitrate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Kbps int `json:"kbps"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if err := ValidateVideoBitrate(req.Kbps); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if s.videoPipeline == nil {
+		writeError(w, http.StatusConflict, fmt.Errorf("no video stream"))
+		return
+	}
+	if s.config.ABR {
+		writeError(w, http.StatusConflict, fmt.Errorf("the bitrate is adapted by --abr"))
+		return
+	}
+	// Not on the main loop: an encoder swap waits for the streaming thread
+	err := changeVideoBitrate(s.videoPipeline, s.config.Encoder, req.Kbps)
+	s.reply(w, err, "[control] video bitrate %d kbps", req.Kbps)
+}
+
+func (s *ControlServer) handleKeyframe(w http.ResponseWriter, r *http.Request) {
+	if s.keyframes == nil {
+		writeError(w, http.StatusConflict, fmt.Errorf("no video stream"))
+		return
+	}
+	if !s.keyframes.Request(keyframeReasonAPI) {
+		s.reply(w, nil, "[control] keyframe requested, coalesced with a recent one")
+		return
+	}
+	s.reply(w, nil, "[control] keyframe requested")
+}
+
+func (s *ControlServer) handleRestart(w http.ResponseWriter, r *http.Request) {
+	err := runOnMainLoop(func() error {
+		for _, pipeline := range s.pipelines {
+			if err := pipeline.BlockSetState(gst.StateNull); err != nil {
+				return fmt.Errorf("failed to stop %s: %w", pipeline.GetName(), err)
+			}
+		}
+		for _, pipeline := range s.pipelines {
+			if err := pipeline.SetState(gst.StatePlaying); err != nil {
+				return fmt.Errorf("failed to start %s: %w", pipeline.GetName(), err)
+			}
+		}
+		s.mu.Lock()
+		s.paused = false
+		s.mu.Unlock()
+		return nil
+	})
+	s.reply(w, err, "[control] pipelines restarted")
+}
+
+func (s *ControlServer) handlePause(w http.ResponseWriter, r *http.Request) {
+	// An empty body pauses, {"paused": false} resumes
+	req := struct {
+		Paused bool `json:"paused"`
+	}{Paused: true}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	state := gst.StatePlaying
+	if req.Paused {
+		state = gst.StatePaused
+	}
+	err := runOnMainLoop(func() error {
+		for _, pipeline := range s.pipelines {
+			if err := pipeline.SetState(state); err != nil {
+				return fmt.Errorf("failed to set %s to %s: %w", pipeline.GetName(), state, err)
+			}
+		}
+		s.mu.Lock()
+		s.paused = req.Paused
+		s.mu.Unlock()
+		return nil
+	})
+	s.reply(w, err, "[control] pipelines %s", state)
+}
+
+func (s *ControlServer) handleDestination(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Action  string `json:"action"` // add or remove
+		Address string `json:"address"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	d, err := s.dests.Parse(req.Address)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid destination: %w", err))
+		return
+	}
+	switch req.Action {
+	case "add":
+		err = s.dests.Add(d)
+	case "remove":
+		err = s.dests.Remove(d)
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("action must be add or remove, got: %q", req.Action))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	s.reply(w, nil, "[control] %s %s", req.Action, d)
+}
+
+func (s *ControlServer) handleSource(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Source string `json:"source"` // device or test
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	var test bool
+	switch req.Source {
+	case "test":
+		test = true
+	case "device":
+		if (s.videoPipeline != nil && s.config.VideoDevice == nil) || (s.audioPipeline != nil && s.config.AudioDevice == nil) {
+			writeError(w, http.StatusConflict, fmt.Errorf("the test source was selected at startup, there is no device to switch to"))
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("source must be device or test, got: %q", req.Source))
+		return
+	}
+
+	err := runOnMainLoop(func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.videoPipeline != nil && s.videoTest != test {
+			if err := replaceSource(s.videoPipeline, videoSourceName, func() (*gst.Element, error) {
+				return createVideoSource(s.config, test)
+			}); err != nil {
+				return err
+			}
+			s.videoTest = test
+		}
+		if s.audioPipeline != nil && s.audioTest != test {
+			if err := replaceSource(s.audioPipeline, audioSourceName, func() (*gst.Element, error) {
+				return createAudioSource(s.config, test)
+			}); err != nil {
+				return err
+			}
+			s.audioTest = test
+		}
+		return nil
+	})
+	s.reply(w, err, "[control] switched to %s source", req.Source)
+}
+
+// reply logs a successful change and answers with the new status, or with the error
+func (s *ControlServer) reply(w http.ResponseWriter, err error, format string, args ...any) {
+	if err != nil {
+		fmt.Printf("[control] %v\n", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	fmt.Printf(format+"\n", args...)
+	var status controlStatus
+	runOnMainLoop(func() error {
+		status = s.status()
+		return nil
+	})
+	writeJSON(w, http.StatusOK, status)
+}
+
+// replaceSource swaps the source at the head of a branch for a new one while
+// the rest of the pipeline keeps running. Must run on the main loop.
+func replaceSource(pipeline *gst.Pipeline, name string, create func() (*gst.Element, error)) error {
+	old, err := pipeline.GetElementByName(name)
+	if err != nil {
+		return fmt.Errorf("failed to find %s: %w", name, err)
+	}
+	peer := old.GetStaticPad("src").GetPeer()
+	if peer == nil {
+		return fmt.Errorf("%s is not linked", name)
+	}
+	next := peer.GetParentElement()
+
+	src, err := create()
+	if err != nil {
+		return err
+	}
+	if err := old.BlockSetState(gst.StateNull); err != nil {
+		return fmt.Errorf("failed to stop %s: %w", name, err)
+	}
+	old.Unlink(next)
+	pipeline.Remove(old)
+
+	pipeline.Add(src)
+	if err := src.Link(next); err != nil {
+		return fmt.Errorf("failed to link %s to %s: %w", src.GetName(), next.GetName(), err)
+	}
+	if !src.SyncStateWithParent() {
+		return fmt.Errorf("failed to start %s", src.GetName())
+	}
+	return nil
+}
+
+// describeSource names a source for the status
+func describeSource(test bool) string {
+	if test {
+		return "test"
+	}
+	return "device"
+}
+
+// runOnMainLoop runs fn on the GLib main loop and waits for its result
+func runOnMainLoop(fn func() error) error {
+	done := make(chan error, 1)
+	glib.IdleAdd(func() bool {
+		done <- fn()
+		return false
+	})
+	return <-done
+}
+
+// readJSON decodes an optional JSON request body, answering 400 on errors
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, controlMaxBody)).Decode(v)
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid JSON body: %w", err))
+		return false
+	}
+	return true
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-gst/go-glib/glib"
+	"github.com/go-gst/go-gst/gst"
+)
+
+// Destination represents a single receiver of the video and audio streams
+type Destination struct {
+	Host          string // Resolved address passed to the sink
+	HostName      string // Host as given on the command line
+	Port          int
+	AudioPort     int
+	RTCPPort      int
+	AudioRTCPPort int
+	FECPort       int // ST 2022-1 column FEC, row FEC uses FECPort + 1
+	TelemetryPort int // KLV telemetry RTP, its RTCP uses TelemetryPort + 1
+}
+
+// PortLayout holds port overrides shared by all destinations. Zero values are
+// derived from each destination's video port.
+type PortLayout struct {
+	RTCPPort      int // Video RTCP, audio uses +1 (default video port + 2)
+	FECPort       int // Column FEC, row FEC uses +1 (default video port + 6)
+	TelemetryPort int // Telemetry RTP, its RTCP uses +1 (default video port + 8)
+}
+
+// parseDestination parses and resolves a host:port destination. The audio port
+// is derived from the video port (video port + 1), the RTCP, FEC and telemetry
+// ports from the layout or, when not set, from the video port.
+func parseDestination(addr string, layout PortLayout) (Destination, error) {
+	host, port, err := parseAddress(addr)
+	if err != nil {
+		return Destination{}, err
+	}
+	rtcpPort := layout.RTCPPort
+	if rtcpPort == 0 {
+		rtcpPort = port + 2
+	}
+	fecPort := layout.FECPort
+	if fecPort == 0 {
+		fecPort = port + 6
+	}
+	telemetryPort := layout.TelemetryPort
+	if telemetryPort == 0 {
+		telemetryPort = port + 8
+	}
+	if port+1 > 65535 || rtcpPort+1 > 65535 || fecPort+1 > 65535 || telemetryPort+1 > 65535 {
+		return Destination{}, fmt.Errorf("port %d leaves no room for the audio, RTCP, FEC and telemetry ports", port)
+	}
+
+	resolved, err := resolveHost(host)
+	if err != nil {
+		return Destination{}, err
+	}
+
+	return Destination{
+		Host:          resolved,
+		HostName:      host,
+		Port:          port,
+		AudioPort:     port + 1,
+		RTCPPort:      rtcpPort,
+		AudioRTCPPort: rtcpPort + 1,
+		FECPort:       fecPort,
+		TelemetryPort: telemetryPort,
+	}, nil
+}
+
+// String returns the destination as given on the command line
+func (d Destination) String() string {
+	return net.JoinHostPort(d.HostName, strconv.Itoa(d.Port))
+}
+
+// buildClients formats destinations as a multiudpsink clients string using the
+// given port selector. multiudpsink splits on the last colon, so IPv6 addresses
+// are written without brackets.
+func buildClients(dests []Destination, port func(Destination) int) string {
+	clients := make([]string, 0, len(dests))
+	for _, d := range dests {
+		clients = append(clients, fmt.Sprintf("%s:%d", d.Host, port(d)))
+	}
+	return strings.Join(clients, ",")
+}
+
+func videoPort(d Destination) int         { return d.Port }
+func audioPort(d Destination) int         { return d.AudioPort }
+func videoRTCPPort(d Destination) int     { return d.RTCPPort }
+func audioRTCPPort(d Destination) int     { return d.AudioRTCPPort }
+func fecColumnPort(d Destination) int     { return d.FECPort }
+func fecRowPort(d Destination) int        { return d.FECPort + 1 }
+func telemetryPort(d Destination) int     { return d.TelemetryPort }
+func telemetryRTCPPort(d Destination) int { return d.TelemetryPort + 1 }
+
+// DestinationSet tracks the destinations of the running pipelines and applies
+// additions and removals to their multiudpsinks on the GLib main loop
+type DestinationSet struct {
+	mu            sync.Mutex
+	dests         []Destination
+	ports         PortLayout
+	fec           FECType
+	container     ContainerType
+	telemetry     bool
+	videoPipeline *gst.Pipeline
+	audioPipeline *gst.Pipeline
+}
+
+// NewDestinationSet creates a destination set for the given pipelines
+func NewDestinationSet(config StreamConfig, videoPipeline, audioPipeline *gst.Pipeline) *DestinationSet {
+	return &DestinationSet{
+		dests:         append([]Destination(nil), config.Destinations...),
+		ports:         config.Ports,
+		fec:           config.FEC,
+		container:     config.Container,
+		telemetry:     config.TelemetryRTP,
+		videoPipeline: videoPipeline,
+		audioPipeline: audioPipeline,
+	}
+}
+
+// Parse parses and resolves a destination using the configured port layout
+func (s *DestinationSet) Parse(addr string) (Destination, error) {
+	return parseDestination(addr, s.ports)
+}
+
+// List returns a copy of the current destinations
+func (s *DestinationSet) List() []Destination {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Destination(nil), s.dests...)
+}
+
+// Add starts streaming to a new destination without restarting the pipelines
+func (s *DestinationSet) Add(d Destination) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.indexOf(d) >= 0 {
+		return fmt.Errorf("destination %s already exists", d)
+	}
+	s.dests = append(s.dests, d)
+	s.emit("add", d.Host, d)
+	return nil
+}
+
+// Remove stops streaming to a destination without restarting the pipelines
+func (s *DestinationSet) Remove(d Destination) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(d)
+	if i < 0 {
+		return fmt.Errorf("destination %s not found", d)
+	}
+	// Use the stored address, the caller may have resolved the name differently
+	d = s.dests[i]
+	s.dests = append(s.dests[:i], s.dests[i+1:]...)
+	s.emit("remove", d.Host, d)
+	return nil
+}
+
+// UpdateHost moves every destination using hostName to a newly resolved address
+func (s *DestinationSet) UpdateHost(hostName, addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, d := range s.dests {
+		if d.HostName != hostName || d.Host == addr {
+			continue
+		}
+		s.emit("remove", d.Host, d)
+		s.emit("add", addr, d)
+		s.dests[i].Host = addr
+	}
+}
+
+// indexOf finds a destination by name and ports. Must be called with mu held.
+func (s *DestinationSet) indexOf(d Destination) int {
+	for i, existing := range s.dests {
+		if existing.HostName == d.HostName && existing.Port == d.Port {
+			return i
+		}
+	}
+	return -1
+}
+
+// emit schedules an add/remove action signal on the video, audio and telemetry
+// RTP/RTCP/FEC sinks
+func (s *DestinationSet) emit(signal, host string, d Destination) {
+	glib.IdleAdd(func() bool {
+		emitSinkSignal(s.videoPipeline, "video-sink", signal, host, d.Port)
+		if s.container == ContainerMPEGTS {
+			// Video and audio share the single MPEG-TS stream
+			return false
+		}
+		emitSinkSignal(s.videoPipeline, "video-rtcp-sink", signal, host, d.RTCPPort)
+		if s.fec == FECST2022 {
+			emitSinkSignal(s.videoPipeline, "video-fec-col-sink", signal, host, fecColumnPort(d))
+			emitSinkSignal(s.videoPipeline, "video-fec-row-sink", signal, host, fecRowPort(d))
+		}
+		if s.telemetry {
+			emitSinkSignal(s.videoPipeline, "telemetry-sink", signal, host, telemetryPort(d))
+			emitSinkSignal(s.videoPipeline, "telemetry-rtcp-sink", signal, host, telemetryRTCPPort(d))
+		}
+		emitSinkSignal(s.audioPipeline, "audio-sink", signal, host, d.AudioPort)
+		emitSinkSignal(s.audioPipeline, "audio-rtcp-sink", signal, host, d.AudioRTCPPort)
+		return false
+	})
+}
+
+// emitSinkSignal emits a multiudpsink action signal (add/remove) on the named sink
+func emitSinkSignal(pipeline *gst.Pipeline, sinkName, signal, host string, port int) {
+	if pipeline == nil {
+		return
+	}
+	sink, err := pipeline.GetElementByName(sinkName)
+	if err != nil {
+		fmt.Printf("WARNING: %s not found: %v\n", sinkName, err)
+		return
+	}
+	if _, err := sink.Emit(signal, host, port); err != nil {
+		fmt.Printf("WARNING: failed to %s %s:%d on %s: %v\n", signal, host, port, sinkName, err)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -10,6 +11,9 @@ import (
 	"github.com/go-gst/go-gst/gst"
 )
 
+// errNoDevices is returned when the device monitor finds no device of a class
+var errNoDevices = errors.New("no devices found")
+
 // DeviceSelection represents either a real device or a test source
 type DeviceSelection struct {
 	Device  *gst.Device
@@ -27,7 +31,7 @@ func selectDeviceInteractive(className, capsStr, testSourceName string) (*Device
 	monitor.Stop()
 
 	if len(devices) == 0 {
-		return nil, fmt.Errorf("no devices found for %s", className)
+		return nil, fmt.Errorf("%w for %s", errNoDevices, className)
 	}
 
 	// Display device list
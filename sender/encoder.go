@@ -18,6 +18,7 @@ const (
 	VAH264Enc      EncoderType = "vah264enc"
 	VAH264LPEnc    EncoderType = "vah264lpenc"
 	OpenH264Enc    EncoderType = "openh264enc"
+	X264Enc        EncoderType = "x264enc"
 	MPPH264Enc     EncoderType = "mpph264enc"
 
 	// H.265 encoders
@@ -66,6 +67,7 @@ var validEncoders = map[EncoderType]CodecFamily{
 	VAH264Enc:     CodecH264,
 	VAH264LPEnc:   CodecH264,
 	OpenH264Enc:   CodecH264,
+	X264Enc:       CodecH264,
 	MPPH264Enc:    CodecH264,
 
 	// H.265
@@ -130,6 +132,7 @@ func ListEncoders() string {
 	sb.WriteString("  - vah264enc (VA-API)\n")
 	sb.WriteString("  - vah264lpenc (VA-API low power)\n")
 	sb.WriteString("  - openh264enc (OpenH264)\n")
+	sb.WriteString("  - x264enc (x264)\n")
 	sb.WriteString("  - mpph264enc (Rockchip MPP)\n\n")
 
 	sb.WriteString("H.265:\n")
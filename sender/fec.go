@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-gst/go-gst/gst"
+)
+
+// FECType represents the forward error correction scheme applied to video
+type FECType string
+
+// Supported FEC schemes
+const (
+	FECNone   FECType = ""
+	FECULP    FECType = "ulpfec"   // RFC 5109 ULPFEC in the video RTP stream
+	FECFlex   FECType = "flexfec"  // RFC 8627 FlexFEC
+	FECST2022 FECType = "st2022-1" // SMPTE 2022-1 row/column XOR FEC on separate ports
+)
+
+// ULPFEC packets share the video SSRC and are told apart by payload type
+const fecPayloadType = 122
+
+// Default FEC parameters
+const (
+	defaultFECPercentage = 20
+	defaultFECColumns    = 10
+	defaultFECRows       = 10
+)
+
+// ValidateFEC checks if the FEC scheme is supported
+func ValidateFEC(fec string) (FECType, error) {
+	switch FECType(strings.ToLower(fec)) {
+	case FECNone, "none":
+		return FECNone, nil
+	case FECULP:
+		return FECULP, nil
+	case FECST2022:
+		return FECST2022, nil
+	case FECFlex:
+		// GStreamer has no FlexFEC encoder; ST 2022-1 uses the same row/column
+		// XOR scheme and is the closest available equivalent
+		return "", fmt.Errorf("flexfec is not provided by GStreamer, use st2022-1 (row/column XOR FEC) instead")
+	}
+	return "", fmt.Errorf("unsupported FEC: %s\nSupported: ulpfec, st2022-1", fec)
+}
+
+// parseFECMatrix parses an ST 2022-1 matrix size given as columns x rows (e.g. 10x10)
+func parseFECMatrix(matrix string) (int, int, error) {
+	var columns, rows int
+	if _, err := fmt.Sscanf(strings.ToLower(matrix), "%dx%d", &columns, &rows); err != nil {
+		return 0, 0, fmt.Errorf("expected format COLUMNSxROWS (e.g. 10x10), got: %s", matrix)
+	}
+	// SMPTE 2022-1 limits: L (columns) 1-20, D (rows) 4-20, L*D <= 100
+	if columns < 1 || columns > 20 || rows < 4 || rows > 20 || columns*rows > 100 {
+		return 0, 0, fmt.Errorf("matrix %dx%d out of range (columns 1-20, rows 4-20, columns*rows <= 100)", columns, rows)
+	}
+	return columns, rows, nil
+}
+
+// createFECEncoder creates the FEC encoder placed after the video payloader,
+// or nil when FEC is disabled
+func createFECEncoder(config StreamConfig) (*gst.Element, error) {
+	switch config.FEC {
+	case FECULP:
+		enc, err := gst.NewElementWithName("rtpulpfecenc", "video-fec")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create rtpulpfecenc: %w", err)
+		}
+		enc.SetProperty("pt", uint(fecPayloadType))
+		enc.SetProperty("percentage", uint(config.FECPercentage))
+		return enc, nil
+	case FECST2022:
+		enc, err := gst.NewElementWithName("rtpst2022-1-fecenc", "video-fec")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create rtpst2022-1-fecenc: %w", err)
+		}
+		enc.SetProperty("columns", uint(config.FECColumns))
+		enc.SetProperty("rows", uint(config.FECRows))
+		enc.SetProperty("enable-column-fec", true)
+		enc.SetProperty("enable-row-fec", true)
+		return enc, nil
+	}
+	return nil, nil
+}
+
+// linkFECStreams links the separate column/row FEC streams of an ST 2022-1
+// encoder to their own sinks. ULPFEC travels inside the video RTP stream.
+func linkFECStreams(pipeline *gst.Pipeline, fecEnc *gst.Element, config StreamConfig) error {
+	if config.FEC != FECST2022 {
+		return nil
+	}
+
+	streams := []struct {
+		pad  string
+		name string
+		port func(Destination) int
+	}{
+		{"fec_0", "video-fec-col-sink", fecColumnPort},
+		{"fec_1", "video-fec-row-sink", fecRowPort},
+	}
+	for _, stream := range streams {
+		sink, _ := gst.NewElementWithName("multiudpsink", stream.name)
+		sink.SetProperty("clients", buildClients(config.Destinations, stream.port))
+		sink.SetProperty("sync", false)
+		sink.SetProperty("async", false)
+		pipeline.Add(sink)
+		if err := linkPads(fecEnc, stream.pad, sink, "sink"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildFECCommand returns the gst-launch FEC encoder element, or "" when FEC is disabled
+func buildFECCommand(config StreamConfig) string {
+	switch config.FEC {
+	case FECULP:
+		return fmt.Sprintf("rtpulpfecenc name=video-fec pt=%d percentage=%d", fecPayloadType, config.FECPercentage)
+	case FECST2022:
+		return fmt.Sprintf("rtpst2022-1-fecenc name=video-fec columns=%d rows=%d enable-column-fec=true enable-row-fec=true",
+			config.FECColumns, config.FECRows)
+	}
+	return ""
+}
+
+// buildFECStreamsCommand returns the gst-launch fragments sending ST 2022-1 FEC streams
+func buildFECStreamsCommand(config StreamConfig) []string {
+	if config.FEC != FECST2022 {
+		return nil
+	}
+	return []string{
+		fmt.Sprintf("video-fec.fec_0 ! multiudpsink clients=%s sync=false async=false", buildClients(config.Destinations, fecColumnPort)),
+		fmt.Sprintf("video-fec.fec_1 ! multiudpsink clients=%s sync=false async=false", buildClients(config.Destinations, fecRowPort)),
+	}
+}
+
+// describeFEC returns a human readable description of the FEC settings
+func describeFEC(config StreamConfig) string {
+	switch config.FEC {
+	case FECULP:
+		return fmt.Sprintf("ULPFEC %d%% (pt %d)", config.FECPercentage, fecPayloadType)
+	case FECST2022:
+		return fmt.Sprintf("ST 2022-1 %dx%d", config.FECColumns, config.FECRows)
+	}
+	return "off"
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/go-gst/go-glib/glib"
+	"github.com/go-gst/go-gst/gst"
+)
+
+// defaultKeyframeCooldown is the minimum time between forced keyframes
+const defaultKeyframeCooldown = time.Second
+
+// Reasons a keyframe was requested, counted in the stats
+const (
+	keyframeReasonSignal = "signal"
+	keyframeReasonAPI    = "api"
+	keyframeReasonRTCP   = "rtcp"
+)
+
+// KeyframeRequester forces keyframes from the video encoder on request, at
+// most once per interval. Requests within the interval are coalesced into one
+// keyframe at its end, so a receiver flooding PLIs gets a keyframe per
+// interval instead of an IDR per packet. Safe for concurrent use.
+type KeyframeRequester struct {
+	pipeline *gst.Pipeline
+	cooldown time.Duration
+
+	mu        sync.Mutex
+	last      time.Time
+	pending   bool
+	requests  map[string]int
+	forced    int
+	coalesced int
+}
+
+// NewKeyframeRequester creates a keyframe requester for the encoder of a video pipeline
+func NewKeyframeRequester(pipeline *gst.Pipeline, cooldown time.Duration) *KeyframeRequester {
+	return &KeyframeRequester{
+		pipeline: pipeline,
+		cooldown: cooldown,
+		requests: make(map[string]int),
+	}
+}
+
+// Request asks for a keyframe. It returns false when the request was
+// coalesced into a keyframe forced at the end of the interval.
+func (k *KeyframeRequester) Request(reason string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.requests[reason]++
+	if k.pending {
+		k.coalesced++
+		return false
+	}
+	if wait := k.cooldown - time.Since(k.last); wait > 0 {
+		k.pending = true
+		k.coalesced++
+		time.AfterFunc(wait, func() {
+			k.mu.Lock()
+			defer k.mu.Unlock()
+			k.pending = false
+			k.force()
+		})
+		return false
+	}
+	k.force()
+	return true
+}
+
+// force sends the force-key-unit event to the encoder on the main loop. Must
+// be called with mu held.
+func (k *KeyframeRequester) force() {
+	k.last = time.Now()
+	k.forced++
+	glib.IdleAdd(func() bool {
+		// Looked up each time since the encoder may be replaced while running
+		encoder, err := k.pipeline.GetElementByName(videoEncoderName)
+		if err != nil {
+			fmt.Printf("[keyframe] failed to find video encoder: %v\n", err)
+			return false
+		}
+		if !encoder.GetStaticPad("src").SendEvent(newForceKeyUnitEvent()) {
+			fmt.Printf("[keyframe] %s did not handle the keyframe request\n", encoder.GetName())
+		}
+		return false
+	})
+}
+
+// HandleSignal forces a keyframe on SIGUSR1 (where the platform has it) and
+// returns a function that stops listening
+func (k *KeyframeRequester) HandleSignal() func() {
+	sigs := keyframeSignals()
+	if len(sigs) == 0 {
+		return func() {}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	go func() {
+		for range ch {
+			fmt.Println("[keyframe] requested by signal")
+			k.Request(keyframeReasonSignal)
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(ch)
+	}
+}
+
+// WatchReceivers routes the keyframe requests of receivers through the rate
+// limit. rtpbin turns RTCP PLI and FIR feedback into force-key-unit events
+// travelling upstream from the payloader; they are dropped at its sink pad and
+// replaced by a rate-limited request.
+func (k *KeyframeRequester) WatchReceivers() error {
+	payloader, err := k.pipeline.GetElementByName(videoPayloaderName)
+	if err != nil {
+		return fmt.Errorf("failed to find video payloader: %w", err)
+	}
+	payloader.GetStaticPad("sink").AddProbe(gst.PadProbeTypeEventUpstream, func(pad *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		event := info.GetEvent()
+		if event == nil || event.Type() != gst.EventTypeCustomUpstream {
+			return gst.PadProbeOK
+		}
+		if structure := event.GetStructure(); structure == nil || structure.Name() != "GstForceKeyUnit" {
+			return gst.PadProbeOK
+		}
+		k.Request(keyframeReasonRTCP)
+		return gst.PadProbeDrop
+	})
+	return nil
+}
+
+// Stats reports keyframe requests per reason and the keyframes actually forced
+func (k *KeyframeRequester) Stats() map[string]any {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	stats := map[string]any{
+		"forced":    k.forced,
+		"coalesced": k.coalesced,
+	}
+	for reason, n := range k.requests {
+		stats[reason] = n
+	}
+	return stats
+}
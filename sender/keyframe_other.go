@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// keyframeSignals returns no signals where SIGUSR1 does not exist; use the control API instead
+func keyframeSignals() []os.Signal {
+	return nil
+}
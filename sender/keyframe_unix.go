@@ -0,0 +1,13 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// keyframeSignals returns the signals forcing a keyframe
+func keyframeSignals() []os.Signal {
+	return []os.Signal{syscall.SIGUSR1}
+}
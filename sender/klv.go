@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/go-gst/go-gst/gst/app"
+)
+
+// Telemetry data stream defaults
+const (
+	defaultTelemetryHz = 10
+	maxTelemetryHz     = 100
+	telemetryFeedStdin = "-"
+)
+
+// telemetryKLVKey is the SMPTE 336M universal label of the telemetry units. It
+// lies in the experimental node of the registry; the value is one JSON sample.
+var telemetryKLVKey = []byte{
+	0x06, 0x0E, 0x2B, 0x34, 0x01, 0x01, 0x01, 0x01,
+	0x0F, 0x46, 0x50, 0x56, 0x54, 0x4C, 0x4D, 0x00,
+}
+
+// encodeKLV encodes a KLV unit: the key, the BER length of the value and the value
+func encodeKLV(key, value []byte) []byte {
+	unit := append([]byte(nil), key...)
+	if n := len(value); n < 0x80 {
+		unit = append(unit, byte(n))
+	} else {
+		var length []byte
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		unit = append(unit, 0x80|byte(len(length)))
+		unit = append(unit, length...)
+	}
+	return append(unit, value...)
+}
+
+// addTelemetryBranch adds an appsrc carrying KLV units to a pipeline and sends
+// it through the telemetry session of rtpbin. The appsrc timestamps each unit
+// with the running time of the pipeline clock, which the RTCP sender reports
+// map onto the same NTP timeline as the video frames.
+func addTelemetryBranch(pipeline *gst.Pipeline, rtpbin *gst.Element, config StreamConfig) error {
+	src, err := gst.NewElementWithName("appsrc", "telemetry-src")
+	if err != nil {
+		return fmt.Errorf("failed to create appsrc: %w", err)
+	}
+	src.SetProperty("is-live", true)
+	src.SetProperty("do-timestamp", true)
+	src.SetArg("format", "time")
+	src.SetProperty("caps", gst.NewCapsFromString("meta/x-klv,parsed=true"))
+
+	payloader, err := gst.NewElementWithName("rtpklvpay", "telemetry-pay")
+	if err != nil {
+		return fmt.Errorf("failed to create rtpklvpay: %w", err)
+	}
+	configurePayloader(payloader, telemetrySSRC(config), defaultTelemetryPayloadType)
+
+	if err := addAndLinkElements(pipeline, []*gst.Element{src, payloader}); err != nil {
+		return err
+	}
+	return linkRTPSession(pipeline, rtpbin, payloader, telemetrySession(config), config.Destinations)
+}
+
+// TelemetryStream pushes telemetry samples into the telemetry appsrc: snapshots
+// of the flight controller state, or the lines of a JSON feed as they arrive
+type TelemetryStream struct {
+	config  StreamConfig
+	src     *app.Source
+	done    chan struct{}
+	once    sync.Once
+	samples atomic.Int64
+	bytes   atomic.Int64
+
+	mu   sync.Mutex
+	feed io.Closer
+}
+
+// NewTelemetryStream finds the telemetry appsrc of a pipeline
+func NewTelemetryStream(config StreamConfig, pipeline *gst.Pipeline) (*TelemetryStream, error) {
+	src, err := pipeline.GetElementByName("telemetry-src")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find telemetry source: %w", err)
+	}
+	return &TelemetryStream{
+		config: config,
+		src:    app.SrcFromElement(src),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// Start feeds the stream in the background, from the JSON feed when one is
+// configured and otherwise from telemetry at the configured rate
+func (s *TelemetryStream) Start(telemetry *Telemetry) {
+	if s.config.TelemetryFeed != "" {
+		go func() {
+			err := s.readFeed(s.config.TelemetryFeed)
+			select {
+			case <-s.done:
+			default:
+				fmt.Printf("[telemetry] feed %s ended: %v\n", describeTelemetryFeed(s.config.TelemetryFeed), err)
+			}
+		}()
+		return
+	}
+	go s.sample(telemetry, s.config.TelemetryRate)
+}
+
+// Close stops feeding the stream
+func (s *TelemetryStream) Close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.feed != nil {
+			s.feed.Close()
+		}
+	})
+}
+
+// readFeed pushes one sample per line of newline-delimited JSON. Invalid lines
+// are skipped so one bad sample does not end the stream.
+func (s *TelemetryStream) readFeed(path string) error {
+	var r io.Reader = os.Stdin
+	if path != telemetryFeedStdin {
+		// Opening a named pipe blocks until the writer connects
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.feed = file
+		s.mu.Unlock()
+		r = file
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var sample bytes.Buffer
+		if err := json.Compact(&sample, line); err != nil {
+			fmt.Printf("[telemetry] skipping invalid JSON sample: %v\n", err)
+			continue
+		}
+		s.push(sample.Bytes())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// sample pushes a snapshot of the telemetry rate times per second, skipping
+// ticks without a new message so that every sample carries fresh values
+func (s *TelemetryStream) sample(telemetry *Telemetry, rate int) {
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+	last := 0
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		state := telemetry.Snapshot()
+		if state.Messages == last {
+			continue
+		}
+		last = state.Messages
+		sample, err := telemetrySampleJSON(state)
+		if err != nil {
+			fmt.Printf("[telemetry] failed to encode sample: %v\n", err)
+			continue
+		}
+		s.push(sample)
+	}
+}
+
+// push wraps a sample in a KLV unit and hands it to the appsrc
+func (s *TelemetryStream) push(sample []byte) {
+	unit := encodeKLV(telemetryKLVKey, sample)
+	if ret := s.src.PushBuffer(gst.NewBufferFromBytes(unit)); ret != gst.FlowOK {
+		// Flushing while the pipeline stops
+		return
+	}
+	s.samples.Add(1)
+	s.bytes.Add(int64(len(unit)))
+}
+
+// Stats reports the samples sent on the telemetry stream
+func (s *TelemetryStream) Stats() map[string]any {
+	return map[string]any{
+		"samples": s.samples.Load(),
+		"bytes":   s.bytes.Load(),
+	}
+}
+
+// telemetrySampleJSON encodes the received message groups of a state as one
+// JSON object, in the units of TelemetryState
+func telemetrySampleJSON(state TelemetryState) ([]byte, error) {
+	sample := map[string]any{
+		"time":     state.Updated.UTC(),
+		"messages": state.Messages,
+	}
+	if state.HasAttitude {
+		sample["attitude"] = map[string]any{
+			"roll":  state.Roll,
+			"pitch": state.Pitch,
+			"yaw":   state.Yaw,
+		}
+	}
+	if state.HasGPS {
+		sample["gps"] = map[string]any{
+			"fix":        state.GPSFix,
+			"satellites": state.Satellites,
+			"lat":        state.Latitude,
+			"lon":        state.Longitude,
+			"alt":        state.GPSAltitude,
+			"speed":      state.GPSSpeed,
+		}
+	}
+	if state.HasBattery {
+		sample["battery"] = map[string]any{
+			"voltage":   state.Voltage,
+			"current":   state.Current,
+			"remaining": state.BatteryRemaining,
+		}
+	}
+	if state.HasHUD {
+		sample["hud"] = map[string]any{
+			"airspeed":    state.Airspeed,
+			"groundspeed": state.Groundspeed,
+			"heading":     state.Heading,
+			"throttle":    state.Throttle,
+		}
+	}
+	if state.HasAltitude {
+		sample["altitude"] = map[string]any{
+			"alt":   state.Altitude,
+			"climb": state.Climb,
+		}
+	}
+	if state.HasRC {
+		sample["rc"] = map[string]any{
+			"channels": state.RCChannels,
+			"rssi":     state.RSSI,
+		}
+	}
+	return json.Marshal(sample)
+}
+
+// describeTelemetryFeed names a --telemetry-feed value for messages
+func describeTelemetryFeed(feed string) string {
+	if feed == telemetryFeedStdin {
+		return "stdin"
+	}
+	return feed
+}
+
+// describeTelemetrySource describes where the telemetry stream samples come from
+func describeTelemetrySource(config StreamConfig) string {
+	switch {
+	case config.TelemetryFeed != "":
+		return "JSON feed from " + describeTelemetryFeed(config.TelemetryFeed)
+	case config.MSP != nil:
+		return fmt.Sprintf("MSP, up to %d samples/s", config.TelemetryRate)
+	}
+	return fmt.Sprintf("MAVLink, up to %d samples/s", config.TelemetryRate)
+}
+
+// buildTelemetryCommand returns the gst-launch fragments of the telemetry
+// branch. The appsrc stays empty there since the sender pushes the samples.
+func buildTelemetryCommand(rtpbinName string, config StreamConfig) []string {
+	chain := fmt.Sprintf("appsrc name=telemetry-src is-live=true do-timestamp=true format=time caps=meta/x-klv,parsed=true ! rtpklvpay%s ! %s.send_rtp_sink_%d",
+		payloaderCommandProps(telemetrySSRC(config), defaultTelemetryPayloadType), rtpbinName, telemetrySessionID)
+	return append([]string{chain}, buildRTPSessionCommand(rtpbinName, telemetrySession(config), config.Destinations)...)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-gst/go-gst/gst"
+)
+
+// ladderAuto selects the configured resolution and framerate followed by the
+// lower rungs of defaultLadder
+const ladderAuto = "auto"
+
+// defaultLadder is the quality ladder used by --ladder auto, highest first
+var defaultLadder = []string{"FHD@60", "HD@60", "HD@30", "VGA@30"}
+
+// Decisions before the ladder moves: down after sustained pressure, up after a
+// longer stretch of clean decisions at the maximum bitrate
+const (
+	ladderDownReports = 3
+	ladderUpReports   = 10
+)
+
+// videoCapsName names the capsfilter after videoscale/videorate, whose caps
+// select the resolution and framerate sent to the encoder
+const videoCapsName = "video-caps"
+
+// LadderRung is one resolution and framerate step of the quality ladder
+type LadderRung struct {
+	Resolution Resolution
+	Framerate  int
+}
+
+func (r LadderRung) String() string {
+	return fmt.Sprintf("%s@%d", r.Resolution.Name, r.Framerate)
+}
+
+// pixelRate is the number of pixels per second to encode
+func (r LadderRung) pixelRate() int {
+	return r.Resolution.Width * r.Resolution.Height * r.Framerate
+}
+
+// ParseLadder parses a quality ladder such as FHD@60,HD@60,HD@30,VGA@30. The
+// first rung must be the configured resolution and framerate, and each rung
+// must encode fewer pixels per second than the one before and be no wider or
+// taller. "auto" builds the ladder from defaultLadder below the configured rung.
+func ParseLadder(spec string, resolution Resolution, framerate int) ([]LadderRung, error) {
+	start := LadderRung{Resolution: resolution, Framerate: framerate}
+	if strings.EqualFold(spec, ladderAuto) {
+		ladder := []LadderRung{start}
+		for _, s := range defaultLadder {
+			rung, _ := parseLadderRung(s)
+			if rung.Resolution.Width <= resolution.Width && rung.Resolution.Height <= resolution.Height &&
+				rung.Framerate <= framerate && rung.pixelRate() < ladder[len(ladder)-1].pixelRate() {
+				ladder = append(ladder, rung)
+			}
+		}
+		if len(ladder) == 1 {
+			return nil, fmt.Errorf("no ladder rung below %s, list the rungs instead of auto", start)
+		}
+		return ladder, nil
+	}
+
+	var ladder []LadderRung
+	for _, s := range strings.Split(spec, ",") {
+		rung, err := parseLadderRung(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		if len(ladder) > 0 {
+			prev := ladder[len(ladder)-1]
+			if rung.pixelRate() >= prev.pixelRate() {
+				return nil, fmt.Errorf("ladder rung %s must be below %s", rung, prev)
+			}
+			// Stepping down must not upscale, e.g. FHD@60 to 4K@5
+			if rung.Resolution.Width > prev.Resolution.Width || rung.Resolution.Height > prev.Resolution.Height {
+				return nil, fmt.Errorf("ladder rung %s must not be larger than %s", rung, prev)
+			}
+		}
+		ladder = append(ladder, rung)
+	}
+	if len(ladder) < 2 {
+		return nil, fmt.Errorf("a ladder needs at least two rungs, got: %q", spec)
+	}
+	first := ladder[0]
+	if first.Resolution.Width != resolution.Width || first.Resolution.Height != resolution.Height || first.Framerate != framerate {
+		return nil, fmt.Errorf("the ladder must start at the stream's %s, got: %s", start, first)
+	}
+	return ladder, nil
+}
+
+// parseLadderRung parses a RESOLUTION@FPS rung
+func parseLadderRung(s string) (LadderRung, error) {
+	name, fps, ok := strings.Cut(s, "@")
+	if !ok {
+		return LadderRung{}, fmt.Errorf("invalid ladder rung %q, expected RESOLUTION@FPS such as HD@30", s)
+	}
+	resolution, err := ValidateResolution(name)
+	if err != nil {
+		return LadderRung{}, err
+	}
+	framerate, err := strconv.Atoi(fps)
+	if err != nil || framerate < 1 || framerate > 240 {
+		return LadderRung{}, fmt.Errorf("invalid ladder framerate %q, expected 1 to 240", fps)
+	}
+	return LadderRung{Resolution: resolution, Framerate: framerate}, nil
+}
+
+// describeLadder formats a ladder for the configuration summary
+func describeLadder(ladder []LadderRung) string {
+	rungs := make([]string, len(ladder))
+	for i, rung := range ladder {
+		rungs[i] = rung.String()
+	}
+	return strings.Join(rungs, " -> ")
+}
+
+// setVideoRung renegotiates the running video branch to the resolution and
+// framerate of a rung. videoscale and videorate adapt to the new caps and the
+// encoder reinitializes on them, starting with a keyframe, while the source,
+// the payloader and the sinks keep running with the same SSRC.
+func setVideoRung(pipeline *gst.Pipeline, config StreamConfig, rung LadderRung) error {
+	capsFilter, err := pipeline.GetElementByName(videoCapsName)
+	if err != nil {
+		return fmt.Errorf("failed to find video capsfilter: %w", err)
+	}
+	platform, _ := detectPlatform()
+	config.Resolution = rung.Resolution
+	config.Framerate = rung.Framerate
+	return capsFilter.SetProperty("caps", gst.NewCapsFromString(buildVideoCaps(config, platform)))
+}
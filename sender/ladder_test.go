@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseLadder(t *testing.T) {
+	fhd, _ := ValidateResolution("FHD")
+	for _, tt := range []struct {
+		spec string
+		want string // Rungs, or the error
+	}{
+		{"auto", "FHD@60 -> HD@60 -> HD@30 -> VGA@30"},
+		{"FHD@60,HD@30,VGA@15", "FHD@60 -> HD@30 -> VGA@15"},
+		{"FHD@60,FHD@30", "FHD@60 -> FHD@30"},
+		{"HD@60,VGA@30", "must start at the stream's FHD@60"},
+		{"FHD@60", "at least two rungs"},
+		{"FHD@60,HD@30,HD@30", "must be below"},
+		{"FHD@60,4K@5", "must not be larger than FHD@60"},
+		{"FHD@60,HD@30,QVGA@30,VGA@5", "must not be larger than QVGA@30"},
+		{"FHD@60,HD", "expected RESOLUTION@FPS"},
+	} {
+		t.Run(tt.spec, func(t *testing.T) {
+			ladder, err := ParseLadder(tt.spec, fhd, 60)
+			got := ""
+			if err != nil {
+				got = err.Error()
+			} else {
+				got = describeLadder(ladder)
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("ParseLadder(%q) = %q, want %q", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
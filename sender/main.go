@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -16,71 +17,257 @@ func main() {
 	}
 }
 
-// RunPipeline initializes GStreamer and runs the streaming pipeline
+// RunPipeline runs the streaming pipeline. GStreamer must be initialized and
+// the devices selected (see selectDevices).
 func RunPipeline(config StreamConfig) error {
-	gst.Init(nil)
-
-	// Detect platform
-	platform, err := detectPlatform()
-	if err != nil {
-		return fmt.Errorf("failed to detect platform: %w", err)
+	// WHIP hands video and audio to webrtcbin in one pipeline
+	if config.WHIPURL != "" {
+		return runWHIPPipeline(config)
 	}
-	fmt.Printf("Platform: %s\n\n", platform)
 
-	// Select video device interactively
-	videoSelection, err := selectDeviceInteractive("Video/Source", "video/x-raw", "videotestsrc")
-	if err != nil {
-		return fmt.Errorf("failed to select video device: %w", err)
-	}
-	config.VideoDevice = videoSelection.Device
-	config.UseVideoTestSrc = videoSelection.IsTest
-	fmt.Printf("Selected video: %s\n", videoSelection.Name)
-
-	// Select audio device interactively
-	audioSelection, err := selectDeviceInteractive("Audio/Source", "audio/x-raw", "audiotestsrc")
-	if err != nil {
-		return fmt.Errorf("failed to select audio device: %w", err)
+	// MPEG-TS (over UDP or SRT) carries video and audio muxed in one pipeline
+	if config.Container == ContainerMPEGTS {
+		return runTSPipeline(config)
 	}
-	config.AudioDevice = audioSelection.Device
-	config.UseAudioTestSrc = audioSelection.IsTest
-	fmt.Printf("Selected audio: %s\n", audioSelection.Name)
-	fmt.Println()
 
 	// Print pipeline commands for debugging / manual testing
 	fmt.Println("Pipeline commands:")
 	fmt.Println()
-	fmt.Println("[Video]")
-	fmt.Println(BuildVideoPipelineCommand(config))
-	fmt.Println()
-	fmt.Println("[Audio]")
-	fmt.Println(BuildAudioPipelineCommand(config))
+	if config.SinglePipeline {
+		fmt.Println("[Video + Audio]")
+		fmt.Println(BuildAVPipelineCommand(config))
+	} else if !config.NoVideo {
+		fmt.Println("[Video]")
+		fmt.Println(BuildVideoPipelineCommand(config))
+	}
+	if config.RTX {
+		fmt.Println("# plus " + describeRTX(config))
+	}
+	if config.SRTPKey != nil && (config.SinglePipeline || !config.NoVideo) {
+		fmt.Println("# plus " + describeSRTP(config))
+	}
+	if config.WebPreview != "" {
+		fmt.Println("# plus " + describePreview(config))
+	}
 	fmt.Println()
-
-	// Build pipelines
-	fmt.Println("Building video pipeline...")
-	videoPipeline, err := BuildVideoPipeline(config)
-	if err != nil {
-		return fmt.Errorf("failed to create video pipeline: %w", err)
+	if !config.SinglePipeline && !config.NoAudio {
+		fmt.Println("[Audio]")
+		fmt.Println(BuildAudioPipelineCommand(config))
+		if config.SRTPKey != nil {
+			fmt.Println("# plus " + describeSRTP(config))
+		}
+		fmt.Println()
 	}
 
-	fmt.Println("Building audio pipeline...")
-	audioPipeline, err := BuildAudioPipeline(config)
-	if err != nil {
-		return fmt.Errorf("failed to create audio pipeline: %w", err)
+	// Build pipelines. In single-pipeline mode both branches live in one
+	// pipeline, which then serves as the video and the audio pipeline. A
+	// disabled stream leaves its pipeline nil.
+	var videoPipeline, audioPipeline *gst.Pipeline
+	var pipelines []*gst.Pipeline
+	if config.SinglePipeline {
+		fmt.Println("Building A/V pipeline...")
+		pipeline, err := BuildAVPipeline(config)
+		if err != nil {
+			return fmt.Errorf("failed to create A/V pipeline: %w", err)
+		}
+		if !config.NoVideo {
+			videoPipeline = pipeline
+		}
+		if !config.NoAudio {
+			audioPipeline = pipeline
+		}
+		pipelines = []*gst.Pipeline{pipeline}
+	} else {
+		if !config.NoVideo {
+			fmt.Println("Building video pipeline...")
+			pipeline, err := BuildVideoPipeline(config)
+			if err != nil {
+				return fmt.Errorf("failed to create video pipeline: %w", err)
+			}
+			videoPipeline = pipeline
+			pipelines = append(pipelines, pipeline)
+		}
+		if !config.NoAudio {
+			fmt.Println("Building audio pipeline...")
+			pipeline, err := BuildAudioPipeline(config)
+			if err != nil {
+				return fmt.Errorf("failed to create audio pipeline: %w", err)
+			}
+			audioPipeline = pipeline
+			pipelines = append(pipelines, pipeline)
+		}
 	}
 	fmt.Println()
 
 	// Run the main loop
 	var runErr error
 	examples.RunLoop(func(mainLoop *glib.MainLoop) error {
-		allPipelines := []*gst.Pipeline{videoPipeline, audioPipeline}
-		addPipelineWatch(videoPipeline, "video", mainLoop, allPipelines)
-		addPipelineWatch(audioPipeline, "audio", mainLoop, allPipelines)
+		switch {
+		case config.SinglePipeline:
+			addPipelineWatch(pipelines[0], "av", mainLoop, pipelines)
+		default:
+			if videoPipeline != nil {
+				addPipelineWatch(videoPipeline, "video", mainLoop, pipelines)
+			}
+			if audioPipeline != nil {
+				addPipelineWatch(audioPipeline, "audio", mainLoop, pipelines)
+			}
+		}
+
+		// Destinations can be added/removed at runtime from stdin, unless
+		// stdin carries the telemetry feed
+		dests := NewDestinationSet(config, videoPipeline, audioPipeline)
+		if config.TelemetryFeed != telemetryFeedStdin {
+			go runConsole(os.Stdin, dests)
+		}
+
+		// Re-resolve destination hostnames for dynamic DNS / mDNS names
+		if config.ResolveInterval > 0 {
+			watched := map[string]bool{}
+			for _, d := range config.Destinations {
+				if isIPLiteral(d.HostName) || watched[d.HostName] {
+					continue
+				}
+				watched[d.HostName] = true
+				hostName := d.HostName
+				stop := watchHostResolution(hostName, d.Host, config.ResolveInterval, func(addr string) {
+					dests.UpdateHost(hostName, addr)
+				})
+				defer stop()
+			}
+		}
+
+		// Flight controller telemetry for the OSD and the stats
+		telemetry, stopTelemetry := startTelemetry(config)
+		defer stopTelemetry()
+
+		// Telemetry samples as an RTP stream next to the video
+		var telemetryStream *TelemetryStream
+		if config.TelemetryRTP {
+			stream, err := NewTelemetryStream(config, videoPipeline)
+			if err != nil {
+				return err
+			}
+			stream.Start(telemetry)
+			defer stream.Close()
+			telemetryStream = stream
+		}
+
+		// Keyframes on demand (SIGUSR1, control API) and on receiver PLI/FIR
+		var keyframes *KeyframeRequester
+		if videoPipeline != nil {
+			keyframes = NewKeyframeRequester(videoPipeline, config.KeyframeCooldown)
+			defer keyframes.HandleSignal()()
+			if config.RTCPRecvPort > 0 {
+				if err := keyframes.WatchReceivers(); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Adaptive bitrate from the receiver reports
+		var abr *BitrateController
+		if config.ABR && videoPipeline != nil {
+			abr = NewBitrateController(config, videoPipeline)
+			if err := abr.Start(); err != nil {
+				return err
+			}
+			defer abr.Close()
+		}
+
+		// Statistics, printed periodically and served by the control API
+		stats := NewStatsReporter()
+		if abr != nil {
+			stats.Add("abr", abr.Stats)
+		}
+		if keyframes != nil {
+			stats.Add("keyframes", keyframes.Stats)
+		}
+		if telemetry != nil {
+			stats.Add("telemetry", telemetryStats(telemetry))
+		}
+		if telemetryStream != nil {
+			stats.Add("telemetry-rtp", telemetryStream.Stats)
+		}
+		if config.RTX {
+			stats.Add("video-rtx", rtxStats(videoPipeline, videoSessionID))
+			if config.SinglePipeline && audioPipeline != nil {
+				stats.Add("audio-rtx", rtxStats(audioPipeline, audioSessionID))
+			}
+		}
+		if config.StatsInterval > 0 {
+			stats.Start(config.StatsInterval)
+		}
+
+		// Current SRTP key for receivers, rewritten on every rotation
+		if config.SRTPKeyOut != "" {
+			if err := writeSRTPKey(config.SRTPKeyOut, config.SRTPKey); err != nil {
+				return err
+			}
+		}
+
+		// SDP for third-party receivers, rewritten once the parameter sets are
+		// known and on every SRTP key rotation
+		var sdp *SDPWriter
+		if config.SDPFile != "" {
+			sdp = NewSDPWriter(config.SDPFile, config)
+			if err := sdp.Write(nil); err != nil {
+				return err
+			}
+			if videoPipeline != nil {
+				payloader, err := videoPipeline.GetElementByName(videoPayloaderName)
+				if err != nil {
+					return fmt.Errorf("failed to find video payloader: %w", err)
+				}
+				sdp.Watch(payloader)
+				for i := range config.Renditions {
+					payloader, err := videoPipeline.GetElementByName(renditionPayloaderName(i))
+					if err != nil {
+						return fmt.Errorf("failed to find rendition payloader: %w", err)
+					}
+					sdp.WatchRendition(payloader, i)
+				}
+			}
+			fmt.Printf("Writing SDP to %s\n", config.SDPFile)
+		}
+
+		// Browser preview of the encoded video
+		if config.WebPreview != "" {
+			preview, err := NewPreviewServer(config, videoPipeline)
+			if err != nil {
+				return err
+			}
+			if err := preview.Start(); err != nil {
+				return err
+			}
+			defer preview.Close()
+		}
+
+		// Live OSD values
+		if config.OSD.Enabled() && videoPipeline != nil {
+			if err := startOSD(config, videoPipeline, telemetry); err != nil {
+				return err
+			}
+		}
+
+		if config.SRTPRotate > 0 {
+			startSRTPRotation(config, sdp, pipelines...)
+		}
+
+		// Runtime changes over HTTP
+		if config.ControlListen != "" {
+			control := NewControlServer(config, videoPipeline, audioPipeline, pipelines, dests, stats, keyframes)
+			if err := control.Start(); err != nil {
+				return err
+			}
+			defer control.Close()
+		}
 
 		// Start the pipelines
 		fmt.Println("Starting pipelines...")
-		videoPipeline.SetState(gst.StatePlaying)
-		audioPipeline.SetState(gst.StatePlaying)
+		for _, pipeline := range pipelines {
+			pipeline.SetState(gst.StatePlaying)
+		}
 		fmt.Println("Streaming... Press Ctrl+C to stop.")
 
 		// Block on the main loop
@@ -90,6 +277,48 @@ func RunPipeline(config StreamConfig) error {
 	return runErr
 }
 
+// selectDevices detects the platform and lets the user pick the video and
+// audio sources
+func selectDevices(config *StreamConfig) error {
+	// Detect platform
+	platform, err := detectPlatform()
+	if err != nil {
+		return fmt.Errorf("failed to detect platform: %w", err)
+	}
+	fmt.Printf("Platform: %s\n\n", platform)
+
+	// Select video device interactively
+	if !config.NoVideo {
+		videoSelection, err := selectDeviceInteractive("Video/Source", "video/x-raw", "videotestsrc")
+		if err != nil {
+			return fmt.Errorf("failed to select video device: %w", err)
+		}
+		config.VideoDevice = videoSelection.Device
+		config.UseVideoTestSrc = videoSelection.IsTest
+		fmt.Printf("Selected video: %s\n", videoSelection.Name)
+	}
+
+	// Select audio device interactively. Many airframes have no microphone,
+	// so a missing device only drops the audio stream unless it is required.
+	if !config.NoAudio {
+		audioSelection, err := selectDeviceInteractive("Audio/Source", "audio/x-raw", "audiotestsrc")
+		switch {
+		case errors.Is(err, errNoDevices) && !config.RequireAudio && !config.NoVideo:
+			fmt.Printf("WARNING: %v, continuing video-only (use --require-audio to fail instead)\n", err)
+			config.NoAudio = true
+		case err != nil:
+			return fmt.Errorf("failed to select audio device: %w", err)
+		default:
+			config.AudioDevice = audioSelection.Device
+			config.UseAudioTestSrc = audioSelection.IsTest
+			fmt.Printf("Selected audio: %s\n", audioSelection.Name)
+		}
+	}
+	fmt.Println()
+
+	return nil
+}
+
 func selectFirstDevice(className, capsStr string) (*gst.Device, error) {
 	monitor := gst.NewDeviceMonitor()
 	filterCaps := gst.NewCapsFromString(capsStr)
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// MAVLink frame markers and sizes
+const (
+	mavlinkV1Magic        = 0xFE
+	mavlinkV2Magic        = 0xFD
+	mavlinkV1HeaderLen    = 6
+	mavlinkV2HeaderLen    = 10
+	mavlinkChecksumLen    = 2
+	mavlinkSignatureLen   = 13
+	mavlinkIncompatSigned = 0x01
+)
+
+// MAVLink common message IDs decoded for the OSD
+const (
+	mavlinkSysStatus  = 1
+	mavlinkGPSRawInt  = 24
+	mavlinkAttitude   = 30
+	mavlinkRCChannels = 65
+	mavlinkVFRHUD     = 74
+)
+
+// mavlinkMessageSpec is the CRC seed and the (MAVLink 1) payload length of a message
+type mavlinkMessageSpec struct {
+	crcExtra byte
+	length   int
+}
+
+// mavlinkMessages lists the decoded messages. Without a CRC seed the others
+// cannot be told from noise, so the reader scans through them like garbage.
+var mavlinkMessages = map[uint32]mavlinkMessageSpec{
+	mavlinkSysStatus:  {crcExtra: 124, length: 31},
+	mavlinkGPSRawInt:  {crcExtra: 24, length: 30},
+	mavlinkAttitude:   {crcExtra: 39, length: 28},
+	mavlinkRCChannels: {crcExtra: 118, length: 42},
+	mavlinkVFRHUD:     {crcExtra: 20, length: 20},
+}
+
+// mavlinkMessage is a decoded frame: its ID and its payload, zero-padded to
+// the full length when MAVLink 2 truncated trailing zeros
+type mavlinkMessage struct {
+	id      uint32
+	payload []byte
+}
+
+// readMAVLink decodes MAVLink v1/v2 frames from a link into the telemetry
+// model until the link fails
+func readMAVLink(link io.ReadWriter, telemetry *Telemetry) error {
+	reader := bufio.NewReaderSize(link, 4096)
+	for {
+		msg, err := nextMAVLinkMessage(reader)
+		if err != nil {
+			return err
+		}
+		applyMAVLinkMessage(msg, telemetry)
+	}
+}
+
+// nextMAVLinkMessage returns the next valid frame of a known message. It
+// resynchronizes on the next magic byte after garbage, unknown messages or a
+// bad checksum.
+func nextMAVLinkMessage(reader *bufio.Reader) (mavlinkMessage, error) {
+	for {
+		magic, err := reader.ReadByte()
+		if err != nil {
+			return mavlinkMessage{}, err
+		}
+		if magic != mavlinkV1Magic && magic != mavlinkV2Magic {
+			continue
+		}
+		reader.UnreadByte()
+
+		msg, frameLen, err := parseMAVLinkFrame(reader)
+		if err != nil {
+			return mavlinkMessage{}, err
+		}
+		if frameLen == 0 {
+			// Not a frame we can check, look for the next magic byte
+			reader.Discard(1)
+			continue
+		}
+		reader.Discard(frameLen)
+		return msg, nil
+	}
+}
+
+// parseMAVLinkFrame peeks at the frame starting at the reader position. It
+// returns the frame length to consume, 0 for unknown messages and bad checksums.
+func parseMAVLinkFrame(reader *bufio.Reader) (mavlinkMessage, int, error) {
+	header, err := reader.Peek(2)
+	if err != nil {
+		return mavlinkMessage{}, 0, err
+	}
+	v2 := header[0] == mavlinkV2Magic
+	payloadLen := int(header[1])
+
+	headerLen := mavlinkV1HeaderLen
+	if v2 {
+		headerLen = mavlinkV2HeaderLen
+	}
+	frame, err := reader.Peek(headerLen)
+	if err != nil {
+		return mavlinkMessage{}, 0, err
+	}
+	frameLen := headerLen + payloadLen + mavlinkChecksumLen
+	var id uint32
+	if v2 {
+		if frame[2]&mavlinkIncompatSigned != 0 {
+			frameLen += mavlinkSignatureLen
+		}
+		id = uint32(frame[7]) | uint32(frame[8])<<8 | uint32(frame[9])<<16
+	} else {
+		id = uint32(frame[5])
+	}
+
+	spec, known := mavlinkMessages[id]
+	if !known {
+		return mavlinkMessage{}, 0, nil
+	}
+	if frame, err = reader.Peek(frameLen); err != nil {
+		return mavlinkMessage{}, 0, err
+	}
+
+	crcEnd := headerLen + payloadLen
+	crc := mavlinkCRC(frame[1:crcEnd], spec.crcExtra)
+	if crc != binary.LittleEndian.Uint16(frame[crcEnd:]) {
+		return mavlinkMessage{}, 0, nil
+	}
+
+	payload := make([]byte, max(spec.length, payloadLen))
+	copy(payload, frame[headerLen:crcEnd])
+	return mavlinkMessage{id: id, payload: payload}, frameLen, nil
+}
+
+// mavlinkCRC computes the X.25 checksum of the frame after the magic byte,
+// seeded with the message's CRC extra byte
+func mavlinkCRC(data []byte, crcExtra byte) uint16 {
+	crc := uint16(0xFFFF)
+	accumulate := func(b byte) {
+		tmp := b ^ byte(crc)
+		tmp ^= tmp << 4
+		crc = crc>>8 ^ uint16(tmp)<<8 ^ uint16(tmp)<<3 ^ uint16(tmp)>>4
+	}
+	for _, b := range data {
+		accumulate(b)
+	}
+	accumulate(crcExtra)
+	return crc
+}
+
+// applyMAVLinkMessage decodes a message into the telemetry model. Fields are
+// little-endian and ordered by size as on the wire.
+func applyMAVLinkMessage(msg mavlinkMessage, telemetry *Telemetry) {
+	p := msg.payload
+	u16 := func(offset int) uint16 { return binary.LittleEndian.Uint16(p[offset:]) }
+	u32 := func(offset int) uint32 { return binary.LittleEndian.Uint32(p[offset:]) }
+	f32 := func(offset int) float64 { return float64(math.Float32frombits(u32(offset))) }
+	degrees := func(radians float64) float64 { return radians * 180 / math.Pi }
+
+	switch msg.id {
+	case mavlinkAttitude:
+		telemetry.Update(func(state *TelemetryState) {
+			state.HasAttitude = true
+			state.Roll = degrees(f32(4))
+			state.Pitch = degrees(f32(8))
+			state.Yaw = degrees(f32(12))
+		})
+
+	case mavlinkGPSRawInt:
+		telemetry.Update(func(state *TelemetryState) {
+			state.HasGPS = true
+			state.Latitude = float64(int32(u32(8))) / 1e7
+			state.Longitude = float64(int32(u32(12))) / 1e7
+			state.GPSAltitude = float64(int32(u32(16))) / 1000
+			state.GPSSpeed = float64(u16(24)) / 100
+			state.GPSFix = int(p[28])
+			state.Satellites = int(p[29])
+			if p[29] == math.MaxUint8 {
+				state.Satellites = -1
+			}
+		})
+
+	case mavlinkSysStatus:
+		voltage := u16(14)
+		if voltage == math.MaxUint16 {
+			return
+		}
+		telemetry.Update(func(state *TelemetryState) {
+			state.HasBattery = true
+			state.Voltage = float64(voltage) / 1000
+			state.Current = -1
+			if current := int16(u16(16)); current >= 0 {
+				state.Current = float64(current) / 100
+			}
+			state.BatteryRemaining = int(int8(p[30]))
+		})
+
+	case mavlinkVFRHUD:
+		telemetry.Update(func(state *TelemetryState) {
+			state.HasHUD = true
+			state.HasAltitude = true
+			state.Airspeed = f32(0)
+			state.Groundspeed = f32(4)
+			state.Altitude = f32(8)
+			state.Climb = f32(12)
+			state.Heading = int(int16(u16(16)))
+			state.Throttle = int(u16(18))
+		})
+
+	case mavlinkRCChannels:
+		count := min(int(p[40]), 18)
+		channels := make([]uint16, count)
+		for i := range channels {
+			channels[i] = u16(4 + 2*i)
+		}
+		telemetry.Update(func(state *TelemetryState) {
+			state.HasRC = true
+			state.RCChannels = channels
+			state.RSSI = -1
+			if rssi := p[41]; rssi != math.MaxUint8 {
+				state.RSSI = int(rssi) * 100 / 254
+			}
+		})
+	}
+}
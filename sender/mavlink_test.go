@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+// pipeLink is one end of an in-memory telemetry link
+type pipeLink struct {
+	io.Reader
+	io.Writer
+}
+
+// mavlinkFrame encodes a MAVLink 1 or 2 frame. MAVLink 2 payloads are
+// truncated after the last non-zero byte, as senders do.
+func mavlinkFrame(v2 bool, id uint32, payload []byte) []byte {
+	var frame []byte
+	if v2 {
+		for len(payload) > 1 && payload[len(payload)-1] == 0 {
+			payload = payload[:len(payload)-1]
+		}
+		frame = []byte{mavlinkV2Magic, byte(len(payload)), 0, 0, 7, 1, 1, byte(id), byte(id >> 8), byte(id >> 16)}
+	} else {
+		frame = []byte{mavlinkV1Magic, byte(len(payload)), 7, 1, 1, byte(id)}
+	}
+	frame = append(frame, payload...)
+	return binary.LittleEndian.AppendUint16(frame, mavlinkCRC(frame[1:], mavlinkMessages[id].crcExtra))
+}
+
+// decodeMAVLink feeds a byte stream through a pipe into readMAVLink and
+// returns the telemetry state once the stream ends
+func decodeMAVLink(t *testing.T, stream []byte) TelemetryState {
+	t.Helper()
+	r, w := io.Pipe()
+	go func() {
+		// Small writes, so frames arrive split like over a serial port
+		for len(stream) > 0 {
+			n := min(len(stream), 7)
+			w.Write(stream[:n])
+			stream = stream[n:]
+		}
+		w.Close()
+	}()
+
+	telemetry := NewTelemetry()
+	if err := readMAVLink(pipeLink{r, io.Discard}, telemetry); !errors.Is(err, io.EOF) {
+		t.Fatalf("readMAVLink = %v, want io.EOF at the end of the stream", err)
+	}
+	return telemetry.Snapshot()
+}
+
+func attitudePayload(roll, pitch, yaw float32) []byte {
+	p := make([]byte, 28)
+	binary.LittleEndian.PutUint32(p[4:], math.Float32bits(roll))
+	binary.LittleEndian.PutUint32(p[8:], math.Float32bits(pitch))
+	binary.LittleEndian.PutUint32(p[12:], math.Float32bits(yaw))
+	return p
+}
+
+func gpsRawIntPayload() []byte {
+	p := make([]byte, 30)
+	lat, lon := int32(473977420), int32(-85455940)
+	binary.LittleEndian.PutUint32(p[8:], uint32(lat))  // 47.397742
+	binary.LittleEndian.PutUint32(p[12:], uint32(lon)) // -8.545594
+	binary.LittleEndian.PutUint32(p[16:], 488250)      // 488.25m
+	binary.LittleEndian.PutUint16(p[24:], 1250)        // 12.5m/s
+	p[28] = 3
+	p[29] = 14
+	return p
+}
+
+func sysStatusPayload() []byte {
+	p := make([]byte, 31)
+	binary.LittleEndian.PutUint16(p[14:], 16200)       // 16.2V
+	binary.LittleEndian.PutUint16(p[16:], uint16(850)) // 8.5A
+	p[30] = 76
+	return p
+}
+
+func vfrHUDPayload() []byte {
+	p := make([]byte, 20)
+	binary.LittleEndian.PutUint32(p[0:], math.Float32bits(15.5))
+	binary.LittleEndian.PutUint32(p[4:], math.Float32bits(14.25))
+	binary.LittleEndian.PutUint32(p[8:], math.Float32bits(120.5))
+	binary.LittleEndian.PutUint32(p[12:], math.Float32bits(-1.5))
+	binary.LittleEndian.PutUint16(p[16:], 270)
+	binary.LittleEndian.PutUint16(p[18:], 45)
+	return p
+}
+
+func rcChannelsPayload() []byte {
+	p := make([]byte, 42)
+	for i, us := range []uint16{1500, 1000, 2000, 1500, 1100, 1900, 1500, 1500} {
+		binary.LittleEndian.PutUint16(p[4+2*i:], us)
+	}
+	p[40] = 8
+	p[41] = 127 // 50%
+	return p
+}
+
+func near(got, want float64) bool {
+	return math.Abs(got-want) < 1e-4
+}
+
+func TestMAVLinkCRC(t *testing.T) {
+	// The CRC extra byte is accumulated last, so this is CRC-16/MCRF4XX of "123456789"
+	if got := mavlinkCRC([]byte("12345678"), '9'); got != 0x6F91 {
+		t.Errorf("mavlinkCRC = %#04x, want 0x6f91", got)
+	}
+}
+
+func TestReadMAVLinkMessages(t *testing.T) {
+	for _, version := range []struct {
+		name string
+		v2   bool
+	}{{"v1", false}, {"v2", true}} {
+		t.Run(version.name, func(t *testing.T) {
+			v2 := version.v2
+			t.Run("ATTITUDE", func(t *testing.T) {
+				state := decodeMAVLink(t, mavlinkFrame(v2, mavlinkAttitude, attitudePayload(math.Pi/6, -math.Pi/12, math.Pi)))
+				if !state.HasAttitude || !near(state.Roll, 30) || !near(state.Pitch, -15) || !near(state.Yaw, 180) {
+					t.Errorf("attitude = %v roll %v pitch %v yaw %v, want roll 30 pitch -15 yaw 180",
+						state.HasAttitude, state.Roll, state.Pitch, state.Yaw)
+				}
+			})
+			t.Run("GPS_RAW_INT", func(t *testing.T) {
+				state := decodeMAVLink(t, mavlinkFrame(v2, mavlinkGPSRawInt, gpsRawIntPayload()))
+				if !state.HasGPS || state.GPSFix != 3 || state.Satellites != 14 {
+					t.Errorf("GPS = %v fix %d sats %d, want fix 3 with 14 satellites", state.HasGPS, state.GPSFix, state.Satellites)
+				}
+				if !near(state.Latitude, 47.397742) || !near(state.Longitude, -8.545594) ||
+					!near(state.GPSAltitude, 488.25) || !near(state.GPSSpeed, 12.5) {
+					t.Errorf("GPS position = %v %v alt %v speed %v, want 47.397742 -8.545594 alt 488.25 speed 12.5",
+						state.Latitude, state.Longitude, state.GPSAltitude, state.GPSSpeed)
+				}
+			})
+			t.Run("SYS_STATUS", func(t *testing.T) {
+				state := decodeMAVLink(t, mavlinkFrame(v2, mavlinkSysStatus, sysStatusPayload()))
+				if !state.HasBattery || !near(state.Voltage, 16.2) || !near(state.Current, 8.5) || state.BatteryRemaining != 76 {
+					t.Errorf("battery = %v %vV %vA %d%%, want 16.2V 8.5A 76%%",
+						state.HasBattery, state.Voltage, state.Current, state.BatteryRemaining)
+				}
+			})
+			t.Run("VFR_HUD", func(t *testing.T) {
+				state := decodeMAVLink(t, mavlinkFrame(v2, mavlinkVFRHUD, vfrHUDPayload()))
+				if !state.HasHUD || !near(state.Airspeed, 15.5) || !near(state.Groundspeed, 14.25) ||
+					!near(state.Altitude, 120.5) || !near(state.Climb, -1.5) || state.Heading != 270 || state.Throttle != 45 {
+					t.Errorf("HUD = %+v, want airspeed 15.5 groundspeed 14.25 alt 120.5 climb -1.5 heading 270 throttle 45", state)
+				}
+			})
+			t.Run("RC_CHANNELS", func(t *testing.T) {
+				state := decodeMAVLink(t, mavlinkFrame(v2, mavlinkRCChannels, rcChannelsPayload()))
+				want := []uint16{1500, 1000, 2000, 1500, 1100, 1900, 1500, 1500}
+				if !state.HasRC || len(state.RCChannels) != len(want) || state.RSSI != 50 {
+					t.Fatalf("RC = %v channels %v RSSI %d, want 8 channels and RSSI 50", state.HasRC, state.RCChannels, state.RSSI)
+				}
+				for i := range want {
+					if state.RCChannels[i] != want[i] {
+						t.Errorf("channel %d = %d, want %d", i+1, state.RCChannels[i], want[i])
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestReadMAVLinkTruncatedPayload(t *testing.T) {
+	// Only the roll survives MAVLink 2 truncation, the rest is zero-filled
+	frame := mavlinkFrame(true, mavlinkAttitude, attitudePayload(math.Pi/4, 0, 0))
+	if frame[1] >= 28 {
+		t.Fatalf("payload length %d, want a truncated payload", frame[1])
+	}
+	state := decodeMAVLink(t, frame)
+	if !state.HasAttitude || !near(state.Roll, 45) || state.Pitch != 0 || state.Yaw != 0 {
+		t.Errorf("attitude = roll %v pitch %v yaw %v, want 45 0 0", state.Roll, state.Pitch, state.Yaw)
+	}
+}
+
+func TestReadMAVLinkSigned(t *testing.T) {
+	// The CRC covers the incompat flags, the signature follows it
+	frame := mavlinkFrame(true, mavlinkVFRHUD, vfrHUDPayload())
+	frame[2] = mavlinkIncompatSigned
+	crcEnd := len(frame) - mavlinkChecksumLen
+	binary.LittleEndian.PutUint16(frame[crcEnd:], mavlinkCRC(frame[1:crcEnd], mavlinkMessages[mavlinkVFRHUD].crcExtra))
+	frame = append(frame, make([]byte, mavlinkSignatureLen)...)
+	stream := append(frame, mavlinkFrame(true, mavlinkAttitude, attitudePayload(0.1, 0, 0))...)
+
+	state := decodeMAVLink(t, stream)
+	if !state.HasHUD || state.Heading != 270 {
+		t.Errorf("signed VFR_HUD not decoded: %+v", state)
+	}
+	if !state.HasAttitude || state.Messages != 2 {
+		t.Errorf("messages = %d, want the frame after the signature decoded too", state.Messages)
+	}
+}
+
+func TestReadMAVLinkBadCRC(t *testing.T) {
+	bad := mavlinkFrame(false, mavlinkSysStatus, sysStatusPayload())
+	bad[len(bad)-1] ^= 0xFF
+	good := mavlinkFrame(false, mavlinkAttitude, attitudePayload(0.2, 0, 0))
+
+	state := decodeMAVLink(t, append(bad, good...))
+	if state.HasBattery {
+		t.Error("frame with a bad CRC was decoded")
+	}
+	if !state.HasAttitude || state.Messages != 1 {
+		t.Errorf("messages = %d, want the frame after the bad one decoded", state.Messages)
+	}
+}
+
+func TestReadMAVLinkResync(t *testing.T) {
+	var stream []byte
+	// Garbage, including magic bytes and a length running into the next frame
+	stream = append(stream, 0x00, 0x55, mavlinkV1Magic, 0x10, mavlinkV2Magic, 0xAA, 0x01)
+	stream = append(stream, mavlinkFrame(true, mavlinkGPSRawInt, gpsRawIntPayload())...)
+	// A frame cut off in the middle
+	cut := mavlinkFrame(false, mavlinkVFRHUD, vfrHUDPayload())
+	stream = append(stream, cut[:len(cut)/2]...)
+	// An unknown message, valid otherwise
+	stream = append(stream, mavlinkV1Magic, 2, 0, 1, 1, 0 /* HEARTBEAT */, 0xAB, 0xCD, 0x12, 0x34)
+	stream = append(stream, mavlinkFrame(false, mavlinkSysStatus, sysStatusPayload())...)
+
+	state := decodeMAVLink(t, stream)
+	if !state.HasGPS || !state.HasBattery {
+		t.Errorf("GPS %v battery %v, want both frames found between the garbage", state.HasGPS, state.HasBattery)
+	}
+	if state.HasHUD {
+		t.Error("cut-off VFR_HUD frame was decoded")
+	}
+	if state.Messages != 2 {
+		t.Errorf("messages = %d, want 2", state.Messages)
+	}
+}
+
+func TestReadMAVLinkCapture(t *testing.T) {
+	// Two cycles of a flight controller's MAVLink 2 stream, HEARTBEATs included,
+	// replayed through a pty opened like a serial port
+	capture, err := os.ReadFile("testdata/mavlink_capture.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, slave := openPTY(t)
+	endpoint, err := ParseTelemetryEndpoint("serial:" + slave + ":115200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := openTelemetryLink(endpoint)
+	if err != nil {
+		t.Fatalf("openTelemetryLink(%s): %v", slave, err)
+	}
+	defer link.Close()
+
+	telemetry := NewTelemetry()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- readMAVLink(link, telemetry)
+	}()
+	if _, err := master.Write(capture); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.After(5 * time.Second)
+	for telemetry.Snapshot().Messages < 10 {
+		select {
+		case err := <-errs:
+			t.Fatalf("readMAVLink stopped: %v", err)
+		case <-deadline:
+			t.Fatalf("%d of 10 messages decoded after 5s", telemetry.Snapshot().Messages)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	master.Close()
+	if err := <-errs; err == nil {
+		t.Error("readMAVLink returned nil after the pty closed")
+	}
+
+	state := telemetry.Snapshot()
+	if state.Messages != 10 {
+		t.Errorf("messages = %d, want the 10 known frames, not the HEARTBEATs", state.Messages)
+	}
+	if !state.HasAttitude || !near(state.Roll, 2.5) || !near(state.Pitch, -1.5) || !near(state.Yaw, 91) {
+		t.Errorf("attitude = roll %v pitch %v yaw %v, want 2.5 -1.5 91", state.Roll, state.Pitch, state.Yaw)
+	}
+	if !state.HasGPS || state.GPSFix != 3 || state.Satellites != 13 || !near(state.Latitude, 47.397742) ||
+		!near(state.Longitude, 8.545594) || !near(state.GPSAltitude, 488.25) || !near(state.GPSSpeed, 12.5) {
+		t.Errorf("GPS = fix %d sats %d %v %v alt %v speed %v, want fix 3 sats 13 47.397742 8.545594 alt 488.25 speed 12.5",
+			state.GPSFix, state.Satellites, state.Latitude, state.Longitude, state.GPSAltitude, state.GPSSpeed)
+	}
+	if !state.HasBattery || !near(state.Voltage, 12.38) || !near(state.Current, 15.5) || state.BatteryRemaining != 80 {
+		t.Errorf("battery = %vV %vA %d%%, want 12.38V 15.5A 80%%", state.Voltage, state.Current, state.BatteryRemaining)
+	}
+	if !state.HasHUD || state.Airspeed != 14 || state.Groundspeed != 13.5 || state.Altitude != 52.25 ||
+		state.Climb != 0.5 || state.Heading != 93 || state.Throttle != 49 {
+		t.Errorf("HUD = %+v, want airspeed 14 groundspeed 13.5 alt 52.25 climb 0.5 heading 93 throttle 49", state)
+	}
+	want := []uint16{1500, 1500, 1100, 1500, 1000, 2000, 1500, 1500}
+	if !state.HasRC || len(state.RCChannels) != len(want) || state.RSSI != 74 {
+		t.Fatalf("RC = %v channels %v RSSI %d, want 8 channels and RSSI 74", state.HasRC, state.RCChannels, state.RSSI)
+	}
+	for i := range want {
+		if state.RCChannels[i] != want[i] {
+			t.Errorf("channel %d = %d, want %d", i+1, state.RCChannels[i], want[i])
+		}
+	}
+}
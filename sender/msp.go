@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// MSP v1 framing: "$M<" requests, "$M>" replies and "$M!" errors, followed by
+// size, command, payload and an XOR checksum of size, command and payload
+const (
+	mspPreamble  = '$'
+	mspVersion1  = 'M'
+	mspRequest   = '<'
+	mspReply     = '>'
+	mspError     = '!'
+	defaultMSPHz = 10
+	maxMSPHz     = 50
+)
+
+// MSP commands polled for the OSD
+const (
+	mspRawGPS   = 106
+	mspAttitude = 108
+	mspAltitude = 109
+	mspAnalog   = 110
+)
+
+// mspPolled lists the commands requested on every poll
+var mspPolled = []byte{mspAttitude, mspAnalog, mspAltitude, mspRawGPS}
+
+// mspFrame encodes a request without payload
+func mspFrame(command byte) []byte {
+	return []byte{mspPreamble, mspVersion1, mspRequest, 0, command, command}
+}
+
+// pollMSP polls the flight controller rate times per second and decodes the
+// replies into the telemetry model until the link fails. Replies are read
+// separately, so a silent flight controller does not stall the polling.
+func pollMSP(link io.ReadWriter, telemetry *Telemetry, rate int) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- readMSP(link, telemetry)
+	}()
+
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-errs:
+			return err
+		case <-ticker.C:
+			for _, command := range mspPolled {
+				if _, err := link.Write(mspFrame(command)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// readMSP decodes MSP v1 replies until the link fails. Error replies and
+// frames with a bad checksum are skipped.
+func readMSP(link io.Reader, telemetry *Telemetry) error {
+	reader := bufio.NewReader(link)
+	for {
+		command, payload, err := nextMSPReply(reader)
+		if err != nil {
+			return err
+		}
+		applyMSPReply(command, payload, telemetry)
+	}
+}
+
+// nextMSPReply returns the command and payload of the next valid reply
+func nextMSPReply(reader *bufio.Reader) (byte, []byte, error) {
+	for {
+		header, err := reader.Peek(5)
+		if err != nil {
+			return 0, nil, err
+		}
+		if header[0] != mspPreamble || header[1] != mspVersion1 || (header[2] != mspReply && header[2] != mspError) {
+			reader.Discard(1)
+			continue
+		}
+		size := int(header[3])
+		frame, err := reader.Peek(5 + size + 1)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		checksum := byte(0)
+		for _, b := range frame[3 : 5+size] {
+			checksum ^= b
+		}
+		if checksum != frame[5+size] {
+			// Not a frame after all, look for the next preamble
+			reader.Discard(1)
+			continue
+		}
+		reply := frame[2] == mspReply
+		command := frame[4]
+		payload := append([]byte(nil), frame[5:5+size]...)
+		reader.Discard(len(frame))
+		if reply {
+			return command, payload, nil
+		}
+	}
+}
+
+// applyMSPReply decodes a reply into the telemetry model. Payloads are
+// little-endian; replies shorter than expected are ignored.
+func applyMSPReply(command byte, p []byte, telemetry *Telemetry) {
+	u16 := func(offset int) uint16 { return binary.LittleEndian.Uint16(p[offset:]) }
+	i16 := func(offset int) int16 { return int16(u16(offset)) }
+	i32 := func(offset int) int32 { return int32(binary.LittleEndian.Uint32(p[offset:])) }
+
+	switch command {
+	case mspAttitude:
+		if len(p) < 6 {
+			return
+		}
+		telemetry.Update(func(state *TelemetryState) {
+			state.HasAttitude = true
+			state.Roll = float64(i16(0)) / 10
+			state.Pitch = -float64(i16(2)) / 10 // MSP pitch is positive nose down
+			state.Yaw = float64(i16(4))
+			state.Heading = int(i16(4))
+		})
+
+	case mspAnalog:
+		if len(p) < 7 {
+			return
+		}
+		telemetry.Update(func(state *TelemetryState) {
+			state.HasBattery = true
+			state.Voltage = float64(p[0]) / 10
+			if len(p) >= 9 {
+				// Newer firmware adds the voltage in 0.01V
+				state.Voltage = float64(u16(7)) / 100
+			}
+			state.Current = float64(i16(5)) / 100
+			state.BatteryRemaining = -1
+			state.RSSI = int(u16(3)) * 100 / 1023
+		})
+
+	case mspAltitude:
+		if len(p) < 6 {
+			return
+		}
+		telemetry.Update(func(state *TelemetryState) {
+			state.HasAltitude = true
+			state.Altitude = float64(i32(0)) / 100
+			state.Climb = float64(i16(4)) / 100
+		})
+
+	case mspRawGPS:
+		if len(p) < 16 {
+			return
+		}
+		telemetry.Update(func(state *TelemetryState) {
+			state.HasGPS = true
+			// Betaflight reports fix/no fix, INAV 0-2; either way 3D once fixed
+			state.GPSFix = 0
+			if p[0] > 0 {
+				state.GPSFix = 3
+			}
+			state.Satellites = int(p[1])
+			state.Latitude = float64(i32(2)) / 1e7
+			state.Longitude = float64(i32(6)) / 1e7
+			state.GPSAltitude = float64(i16(10))
+			state.GPSSpeed = float64(u16(12)) / 100
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// mspReplyFrame encodes a reply (or an error reply) to a command
+func mspReplyFrame(direction, command byte, payload []byte) []byte {
+	frame := []byte{mspPreamble, mspVersion1, direction, byte(len(payload)), command}
+	frame = append(frame, payload...)
+	checksum := byte(0)
+	for _, b := range frame[3:] {
+		checksum ^= b
+	}
+	return append(frame, checksum)
+}
+
+func mspAttitudePayload() []byte {
+	p := make([]byte, 6)
+	binary.LittleEndian.PutUint16(p[0:], uint16(125)) // 12.5 degrees right
+	pitch := int16(-50)                               // 5 degrees nose up
+	binary.LittleEndian.PutUint16(p[2:], uint16(pitch))
+	binary.LittleEndian.PutUint16(p[4:], 90)
+	return p
+}
+
+func mspRawGPSPayload() []byte {
+	p := make([]byte, 16)
+	p[0] = 1
+	p[1] = 11
+	lat, lon := int32(473977420), int32(-85455940)
+	binary.LittleEndian.PutUint32(p[2:], uint32(lat))
+	binary.LittleEndian.PutUint32(p[6:], uint32(lon))
+	binary.LittleEndian.PutUint16(p[10:], 488)  // Meters
+	binary.LittleEndian.PutUint16(p[12:], 1200) // 12m/s
+	binary.LittleEndian.PutUint16(p[14:], 900)  // Course, 0.1 degrees
+	return p
+}
+
+func mspAnalogPayload() []byte {
+	p := make([]byte, 9)
+	p[0] = 168                                 // 16.8V, superseded by the 0.01V field
+	binary.LittleEndian.PutUint16(p[1:], 350)  // mAh drawn
+	binary.LittleEndian.PutUint16(p[3:], 1023) // RSSI 100%
+	binary.LittleEndian.PutUint16(p[5:], 1250) // 12.5A
+	binary.LittleEndian.PutUint16(p[7:], 1675) // 16.75V
+	return p
+}
+
+func mspAltitudePayload() []byte {
+	p := make([]byte, 6)
+	binary.LittleEndian.PutUint32(p[0:], 12050) // 120.5m
+	binary.LittleEndian.PutUint16(p[4:], 150)   // 1.5m/s
+	return p
+}
+
+// runMSPResponder plays a flight controller: it reads requests from the
+// sender and answers the commands it has a payload for, the others with an
+// error reply
+func runMSPResponder(t *testing.T, requests io.Reader, replies io.Writer, payloads map[byte][]byte) {
+	reader := bufio.NewReader(requests)
+	for {
+		request := make([]byte, 6)
+		if _, err := io.ReadFull(reader, request); err != nil {
+			return
+		}
+		if request[0] != mspPreamble || request[1] != mspVersion1 || request[2] != mspRequest || request[3] != 0 ||
+			request[5] != request[4] {
+			t.Errorf("malformed request % x", request)
+			return
+		}
+		command := request[4]
+		reply := mspReplyFrame(mspError, command, nil)
+		if payload, ok := payloads[command]; ok {
+			reply = mspReplyFrame(mspReply, command, payload)
+		}
+		if _, err := replies.Write(reply); err != nil {
+			return
+		}
+	}
+}
+
+// pollMSPUntil polls a responder on the master side of a pty through a
+// serial: link on the slave until the state satisfies done, then closes the
+// pty and returns the state
+func pollMSPUntil(t *testing.T, payloads map[byte][]byte, done func(TelemetryState) bool) TelemetryState {
+	t.Helper()
+	master, slave := openPTY(t)
+	endpoint, err := ParseTelemetryEndpoint("serial:" + slave + ":115200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := openTelemetryLink(endpoint)
+	if err != nil {
+		t.Fatalf("openTelemetryLink(%s): %v", slave, err)
+	}
+	defer link.Close()
+	go runMSPResponder(t, master, master, payloads)
+
+	telemetry := NewTelemetry()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- pollMSP(link, telemetry, maxMSPHz)
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for !done(telemetry.Snapshot()) {
+		select {
+		case err := <-errs:
+			t.Fatalf("pollMSP stopped: %v", err)
+		case <-deadline:
+			t.Fatalf("telemetry not complete after 5s: %+v", telemetry.Snapshot())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	master.Close()
+	if err := <-errs; err == nil {
+		t.Error("pollMSP returned nil after the pty closed")
+	}
+	return telemetry.Snapshot()
+}
+
+func TestPollMSP(t *testing.T) {
+	state := pollMSPUntil(t, map[byte][]byte{
+		mspAttitude: mspAttitudePayload(),
+		mspRawGPS:   mspRawGPSPayload(),
+		mspAnalog:   mspAnalogPayload(),
+		mspAltitude: mspAltitudePayload(),
+	}, func(state TelemetryState) bool {
+		return state.HasAttitude && state.HasGPS && state.HasBattery && state.HasAltitude
+	})
+
+	if state.Roll != 12.5 || state.Pitch != 5 || state.Yaw != 90 || state.Heading != 90 {
+		t.Errorf("attitude = roll %v pitch %v yaw %v heading %d, want 12.5 5 90 90", state.Roll, state.Pitch, state.Yaw, state.Heading)
+	}
+	if state.GPSFix != 3 || state.Satellites != 11 || !near(state.Latitude, 47.397742) || !near(state.Longitude, -8.545594) ||
+		state.GPSAltitude != 488 || state.GPSSpeed != 12 {
+		t.Errorf("GPS = fix %d sats %d %v %v alt %v speed %v, want fix 3 sats 11 47.397742 -8.545594 alt 488 speed 12",
+			state.GPSFix, state.Satellites, state.Latitude, state.Longitude, state.GPSAltitude, state.GPSSpeed)
+	}
+	if state.Voltage != 16.75 || state.Current != 12.5 || state.BatteryRemaining != -1 || state.RSSI != 100 {
+		t.Errorf("analog = %vV %vA %d%% RSSI %d, want 16.75V 12.5A unknown remaining RSSI 100",
+			state.Voltage, state.Current, state.BatteryRemaining, state.RSSI)
+	}
+	if state.Altitude != 120.5 || state.Climb != 1.5 {
+		t.Errorf("altitude = %v climb %v, want 120.5 1.5", state.Altitude, state.Climb)
+	}
+	if state.HasHUD {
+		t.Error("HasHUD set, MSP has no HUD message")
+	}
+	if got, want := telemetryOSDText(OSDSpeed, state), "SPD 43km/h"; got != want {
+		t.Errorf("speed OSD = %q, want the GPS speed %q", got, want)
+	}
+}
+
+func TestPollMSPAltitudeWithoutGPS(t *testing.T) {
+	state := pollMSPUntil(t, map[byte][]byte{
+		mspAltitude: mspAltitudePayload(),
+	}, func(state TelemetryState) bool {
+		return state.HasAltitude
+	})
+
+	if state.HasGPS || state.HasHUD || state.HasAttitude || state.HasBattery {
+		t.Errorf("state = %+v, want only the altitude from the error replies of the others", state)
+	}
+	if got, want := telemetryOSDText(OSDAltitude, state), "ALT 120m +1.5m/s"; got != want {
+		t.Errorf("altitude OSD = %q, want %q", got, want)
+	}
+	if got, want := telemetryOSDText(OSDSpeed, state), "SPD --"; got != want {
+		t.Errorf("speed OSD = %q, want %q without a speed source", got, want)
+	}
+}
+
+func TestReadMSPResync(t *testing.T) {
+	var stream []byte
+	stream = append(stream, 'x', mspPreamble, mspVersion1, 0x00)
+	bad := mspReplyFrame(mspReply, mspAltitude, mspAltitudePayload())
+	bad[len(bad)-1] ^= 0xFF
+	stream = append(stream, bad...)
+	// A short reply from old firmware is ignored
+	stream = append(stream, mspReplyFrame(mspReply, mspRawGPS, mspRawGPSPayload()[:8])...)
+	// Analog without the 0.01V field
+	stream = append(stream, mspReplyFrame(mspReply, mspAnalog, mspAnalogPayload()[:7])...)
+
+	telemetry := NewTelemetry()
+	if err := readMSP(bytes.NewReader(stream), telemetry); !errors.Is(err, io.EOF) {
+		t.Fatalf("readMSP = %v, want io.EOF at the end of the stream", err)
+	}
+	state := telemetry.Snapshot()
+	if state.HasAltitude {
+		t.Error("reply with a bad checksum was decoded")
+	}
+	if state.HasGPS {
+		t.Error("short MSP_RAW_GPS reply was decoded")
+	}
+	if !state.HasBattery || state.Voltage != 16.8 || state.Messages != 1 {
+		t.Errorf("battery = %v %vV after %d messages, want 16.8V from the only valid reply", state.HasBattery, state.Voltage, state.Messages)
+	}
+}
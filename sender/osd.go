@@ -0,0 +1,355 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-gst/go-glib/glib"
+	"github.com/go-gst/go-gst/gst"
+)
+
+// OSDKind is one element of the on-screen display
+type OSDKind string
+
+// Supported OSD elements
+const (
+	OSDClock     OSDKind = "clock"     // Wall clock
+	OSDHost      OSDKind = "host"      // Sender hostname
+	OSDEncoder   OSDKind = "encoder"   // Encoder and measured bitrate
+	OSDFPS       OSDKind = "fps"       // Measured encoded framerate
+	OSDText      OSDKind = "text"      // Custom text (--osd-text)
+	OSDCrosshair OSDKind = "crosshair" // Center mark
+
+	// Telemetry elements, drawn from the flight controller link
+	OSDHorizon  OSDKind = "horizon"  // Artificial horizon
+	OSDAltitude OSDKind = "altitude" // Altitude and climb rate
+	OSDSpeed    OSDKind = "speed"    // Ground speed and heading
+	OSDBattery  OSDKind = "battery"  // Voltage, current and remaining charge
+	OSDGPS      OSDKind = "gps"      // Fix, satellites and position
+)
+
+// OSDPosition places an OSD element on the frame, e.g. "top-left"
+type OSDPosition string
+
+// defaultOSDFont is the Pango font of the OSD text. textoverlay scales it with
+// the frame size, so it reads the same at every resolution.
+const defaultOSDFont = "Sans 14"
+
+// osdUpdateInterval is how often the live OSD values are refreshed
+const osdUpdateInterval = time.Second
+
+// osdTelemetryInterval is how often the telemetry elements are redrawn
+const osdTelemetryInterval = 100 * time.Millisecond
+
+// osdHorizonFont draws the horizon; its character grid needs a monospace font
+const osdHorizonFont = "Monospace 10"
+
+// osdKinds lists the OSD elements in overlay order
+var osdKinds = []OSDKind{OSDClock, OSDHost, OSDEncoder, OSDFPS, OSDText, OSDCrosshair,
+	OSDHorizon, OSDAltitude, OSDSpeed, OSDBattery, OSDGPS}
+
+// osdDefaultPositions places each element when the spec gives no position
+var osdDefaultPositions = map[OSDKind]OSDPosition{
+	OSDClock:     "top-left",
+	OSDHost:      "top-right",
+	OSDEncoder:   "bottom-left",
+	OSDFPS:       "bottom-right",
+	OSDText:      "top-center",
+	OSDCrosshair: "center",
+	OSDHorizon:   "center",
+	OSDAltitude:  "center-right",
+	OSDSpeed:     "center-left",
+	OSDBattery:   "bottom-center",
+	OSDGPS:       "top-center",
+}
+
+// isTelemetryOSD reports whether an OSD element needs a telemetry link
+func isTelemetryOSD(kind OSDKind) bool {
+	switch kind {
+	case OSDHorizon, OSDAltitude, OSDSpeed, OSDBattery, OSDGPS:
+		return true
+	}
+	return false
+}
+
+// osdPresets are the --osd shorthands
+var osdPresets = map[string]string{
+	"minimal":   "clock,crosshair",
+	"full":      "clock,host,encoder,fps,crosshair",
+	"telemetry": "horizon,altitude,speed,battery,gps",
+}
+
+// OSDItem is one element of the OSD and where it is drawn
+type OSDItem struct {
+	Kind     OSDKind
+	Position OSDPosition
+}
+
+// OSDConfig holds the OSD layout. No items means no overlay.
+type OSDConfig struct {
+	Items []OSDItem
+	Font  string // Pango font description, e.g. "Sans Bold 16"
+	Text  string // Custom text of the text element
+}
+
+// Enabled reports whether any OSD element is drawn
+func (o OSDConfig) Enabled() bool {
+	return len(o.Items) > 0
+}
+
+// ParseOSD parses an --osd spec: "off", a preset, or a comma-separated list of
+// elements with optional positions such as "clock@top-left,fps,crosshair".
+// Custom text adds the text element unless the spec already places it. An
+// empty spec shows the telemetry preset when there is a telemetry link.
+func ParseOSD(spec, text, font string, hasTelemetry bool) (OSDConfig, error) {
+	osd := OSDConfig{Font: font, Text: text}
+	if osd.Font == "" {
+		osd.Font = defaultOSDFont
+	}
+
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	if spec == "" && hasTelemetry {
+		spec = "telemetry"
+	}
+	if preset, ok := osdPresets[spec]; ok {
+		spec = preset
+	}
+	seen := map[OSDKind]bool{}
+	if spec != "" && spec != "off" {
+		for _, field := range strings.Split(spec, ",") {
+			name, position, _ := strings.Cut(strings.TrimSpace(field), "@")
+			kind := OSDKind(name)
+			if _, ok := osdDefaultPositions[kind]; !ok {
+				return OSDConfig{}, fmt.Errorf("unknown OSD element %q, expected one of %s or a preset (minimal, full, telemetry)", name, listOSDKinds())
+			}
+			if isTelemetryOSD(kind) && !hasTelemetry {
+				return OSDConfig{}, fmt.Errorf("OSD element %s needs a telemetry link (--mavlink or --msp)", kind)
+			}
+			if seen[kind] {
+				return OSDConfig{}, fmt.Errorf("OSD element %s is listed twice", kind)
+			}
+			seen[kind] = true
+			item := OSDItem{Kind: kind, Position: osdDefaultPositions[kind]}
+			if position != "" {
+				item.Position = OSDPosition(position)
+				if _, _, err := item.Position.alignment(); err != nil {
+					return OSDConfig{}, err
+				}
+			}
+			osd.Items = append(osd.Items, item)
+		}
+	}
+
+	if seen[OSDText] && text == "" {
+		return OSDConfig{}, fmt.Errorf("the OSD text element needs --osd-text")
+	}
+	if text != "" && !seen[OSDText] {
+		osd.Items = append(osd.Items, OSDItem{Kind: OSDText, Position: osdDefaultPositions[OSDText]})
+	}
+	return osd, nil
+}
+
+// listOSDKinds returns the OSD element names for messages and help
+func listOSDKinds() string {
+	names := make([]string, len(osdKinds))
+	for i, kind := range osdKinds {
+		names[i] = string(kind)
+	}
+	return strings.Join(names, ", ")
+}
+
+// alignment maps a position to the textoverlay halignment and valignment nicks
+func (p OSDPosition) alignment() (string, string, error) {
+	if p == "center" {
+		return "center", "center", nil
+	}
+	vertical, horizontal, ok := strings.Cut(string(p), "-")
+	if !ok {
+		return "", "", fmt.Errorf("invalid OSD position %q, expected center or top/center/bottom-left/center/right", p)
+	}
+	switch vertical {
+	case "top", "center", "bottom":
+	default:
+		return "", "", fmt.Errorf("invalid OSD position %q, expected center or top/center/bottom-left/center/right", p)
+	}
+	switch horizontal {
+	case "left", "center", "right":
+	default:
+		return "", "", fmt.Errorf("invalid OSD position %q, expected center or top/center/bottom-left/center/right", p)
+	}
+	return horizontal, vertical, nil
+}
+
+// describeOSD returns a short description such as "clock@top-left, crosshair@center"
+func describeOSD(osd OSDConfig) string {
+	items := make([]string, len(osd.Items))
+	for i, item := range osd.Items {
+		items[i] = fmt.Sprintf("%s@%s", item.Kind, item.Position)
+	}
+	return fmt.Sprintf("%s (%s)", strings.Join(items, ", "), osd.Font)
+}
+
+// osdElementName is the name of the overlay drawing an OSD element
+func osdElementName(kind OSDKind) string {
+	return "osd-" + string(kind)
+}
+
+// osdInitialText returns the text an element starts with. Live elements are
+// rewritten by startOSD once values are measured.
+func osdInitialText(config StreamConfig, kind OSDKind) string {
+	switch kind {
+	case OSDHost:
+		host, err := os.Hostname()
+		if err != nil {
+			return "unknown host"
+		}
+		return host
+	case OSDEncoder:
+		return string(config.Encoder)
+	case OSDFPS:
+		return "-- fps"
+	case OSDText:
+		return config.OSD.Text
+	case OSDCrosshair:
+		return "+"
+	}
+	if isTelemetryOSD(kind) {
+		return telemetryOSDText(kind, TelemetryState{})
+	}
+	return ""
+}
+
+// osdFont returns the font of an OSD element
+func osdFont(osd OSDConfig, kind OSDKind) string {
+	if kind == OSDHorizon {
+		return osdHorizonFont
+	}
+	return osd.Font
+}
+
+// osdShaded reports whether an element is drawn on a shaded background. The
+// center marks stay see-through so they do not hide the view.
+func osdShaded(kind OSDKind) bool {
+	return kind != OSDCrosshair && kind != OSDHorizon
+}
+
+// createOSDOverlays creates one overlay per OSD element, chained in order.
+// They work on raw system-memory frames, so they go right after the capsfilter,
+// before any upload to NVMM or VA memory.
+func createOSDOverlays(config StreamConfig) ([]*gst.Element, error) {
+	var elements []*gst.Element
+	for _, item := range config.OSD.Items {
+		factory := "textoverlay"
+		if item.Kind == OSDClock {
+			factory = "clockoverlay"
+		}
+		overlay, err := gst.NewElementWithName(factory, osdElementName(item.Kind))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", factory, err)
+		}
+		halign, valign, _ := item.Position.alignment()
+		overlay.SetArg("halignment", halign)
+		overlay.SetArg("valignment", valign)
+		overlay.SetProperty("font-desc", osdFont(config.OSD, item.Kind))
+		if item.Kind == OSDClock {
+			overlay.SetProperty("time-format", "%H:%M:%S")
+		} else {
+			overlay.SetProperty("text", osdInitialText(config, item.Kind))
+		}
+		overlay.SetProperty("shaded-background", osdShaded(item.Kind))
+		elements = append(elements, overlay)
+	}
+	return elements, nil
+}
+
+// buildOSDCommand returns the gst-launch overlay elements of the OSD
+func buildOSDCommand(config StreamConfig) []string {
+	var parts []string
+	for _, item := range config.OSD.Items {
+		halign, valign, _ := item.Position.alignment()
+		props := fmt.Sprintf("name=%s halignment=%s valignment=%s font-desc=%s shaded-background=%t",
+			osdElementName(item.Kind), halign, valign, strconv.Quote(osdFont(config.OSD, item.Kind)), osdShaded(item.Kind))
+		if item.Kind == OSDClock {
+			parts = append(parts, "clockoverlay "+props+` time-format="%H:%M:%S"`)
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("textoverlay %s text=%s", props, strconv.Quote(osdInitialText(config, item.Kind))))
+	}
+	return parts
+}
+
+// startOSD keeps the live OSD elements up to date: the framerate and bitrate
+// measured at the encoder output, and the telemetry elements. Runs its updates
+// on the GLib main loop.
+func startOSD(config StreamConfig, pipeline *gst.Pipeline, telemetry *Telemetry) error {
+	overlays := make(map[OSDKind]*gst.Element)
+	var telemetryKinds []OSDKind
+	for _, item := range config.OSD.Items {
+		telemetryOSD := isTelemetryOSD(item.Kind) && telemetry != nil
+		if item.Kind != OSDEncoder && item.Kind != OSDFPS && !telemetryOSD {
+			continue
+		}
+		overlay, err := pipeline.GetElementByName(osdElementName(item.Kind))
+		if err != nil {
+			return fmt.Errorf("failed to find OSD overlay %s: %w", item.Kind, err)
+		}
+		overlays[item.Kind] = overlay
+		if telemetryOSD {
+			telemetryKinds = append(telemetryKinds, item.Kind)
+		}
+	}
+
+	if len(telemetryKinds) > 0 {
+		glib.TimeoutAdd(uint(osdTelemetryInterval.Milliseconds()), func() bool {
+			state := telemetry.Snapshot()
+			for _, kind := range telemetryKinds {
+				overlays[kind].SetProperty("text", telemetryOSDText(kind, state))
+			}
+			return true
+		})
+	}
+
+	if overlays[OSDEncoder] == nil && overlays[OSDFPS] == nil {
+		return nil
+	}
+	encoder, err := pipeline.GetElementByName(videoEncoderName)
+	if err != nil {
+		return fmt.Errorf("failed to find video encoder: %w", err)
+	}
+
+	// Count encoded frames and bytes on the streaming thread, at the input of
+	// the element after the encoder since the encoder may be swapped
+	output := encoder.GetStaticPad("src").GetPeer()
+	if output == nil {
+		return fmt.Errorf("video encoder is not linked")
+	}
+	var frames, bytes atomic.Int64
+	output.AddProbe(gst.PadProbeTypeBuffer, func(_ *gst.Pad, info *gst.PadProbeInfo) gst.PadProbeReturn {
+		if buffer := info.GetBuffer(); buffer != nil {
+			frames.Add(1)
+			bytes.Add(buffer.GetSize())
+		}
+		return gst.PadProbeOK
+	})
+
+	last := time.Now()
+	glib.TimeoutAdd(uint(osdUpdateInterval.Milliseconds()), func() bool {
+		now := time.Now()
+		elapsed := now.Sub(last).Seconds()
+		last = now
+		fps := float64(frames.Swap(0)) / elapsed
+		kbps := float64(bytes.Swap(0)) * 8 / 1000 / elapsed
+		if overlay, ok := overlays[OSDFPS]; ok {
+			overlay.SetProperty("text", fmt.Sprintf("%.0f fps", fps))
+		}
+		if overlay, ok := overlays[OSDEncoder]; ok {
+			overlay.SetProperty("text", fmt.Sprintf("%s %.0f kbps", config.Encoder, kbps))
+		}
+		return true
+	})
+	return nil
+}
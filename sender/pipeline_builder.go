@@ -13,29 +13,187 @@ func BuildVideoPipeline(config StreamConfig) (*gst.Pipeline, error) {
 		return nil, fmt.Errorf("failed to create pipeline: %w", err)
 	}
 
-	// Create source element
-	var src *gst.Element
-	if config.UseVideoTestSrc {
-		src, err = gst.NewElement("videotestsrc")
-		if err != nil {
-			return nil, fmt.Errorf("failed to create videotestsrc: %w", err)
+	// Add RTP session management
+	rtpbin, err := newRTPBin("video-rtpbin", config)
+	if err != nil {
+		return nil, err
+	}
+	pipeline.Add(rtpbin)
+	sessionIDs := []uint{videoSessionID}
+	if config.TelemetryRTP {
+		sessionIDs = append(sessionIDs, telemetrySessionID)
+	}
+	sessionIDs = append(sessionIDs, renditionSessionIDs(config)...)
+	if config.RTX {
+		// Telemetry samples are superseded by the next one, not retransmitted,
+		// and renditions receive no NACKs
+		if err := enableRTX(rtpbin, config, videoSessionID); err != nil {
+			return nil, err
 		}
-		src.SetProperty("is-live", true)
-		src.SetProperty("pattern", "ball")
-	} else {
-		// Use device.CreateElement() for real devices
-		src = config.VideoDevice.CreateElement("")
-		// Set additional properties if not test source
-		platform, _ := detectPlatform()
-		if platform == "darwin" {
-			src.SetProperty("do-stats", true)
-			src.SetProperty("do-timestamp", true)
+	}
+	if config.SRTPKey != nil {
+		if err := enableSRTP(rtpbin, config, sessionIDs...); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := addVideoBranch(pipeline, rtpbin, config); err != nil {
+		return nil, err
+	}
+	// Telemetry shares the video clock so receivers can align samples with frames
+	if config.TelemetryRTP {
+		if err := addTelemetryBranch(pipeline, rtpbin, config); err != nil {
+			return nil, err
+		}
+	}
+	watchReceiverReports(rtpbin)
+
+	return pipeline, nil
+}
+
+// BuildAVPipeline builds video and audio in one pipeline sharing one rtpbin
+// (sessions 0 and 1). Both branches run on the same clock and base time, so the
+// RTCP sender reports of both sessions map RTP timestamps onto the same NTP
+// timeline and receivers can lip-sync audio to video.
+func BuildAVPipeline(config StreamConfig) (*gst.Pipeline, error) {
+	pipeline, err := gst.NewPipeline("av-pipeline")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pipeline: %w", err)
+	}
+
+	// One rtpbin for both sessions. Its request signals fire for every
+	// session, so RTX and SRTP are set up for all of them at once.
+	var sessionIDs []uint
+	if !config.NoVideo {
+		sessionIDs = append(sessionIDs, videoSessionID)
+	}
+	if !config.NoAudio {
+		sessionIDs = append(sessionIDs, audioSessionID)
+	}
+	// Telemetry samples are superseded by the next one, not retransmitted,
+	// and renditions receive no NACKs
+	rtxSessionIDs := append([]uint{}, sessionIDs...)
+	if !config.NoVideo {
+		sessionIDs = append(sessionIDs, renditionSessionIDs(config)...)
+	}
+	if config.TelemetryRTP {
+		sessionIDs = append(sessionIDs, telemetrySessionID)
+	}
+	rtpbin, err := newRTPBin("av-rtpbin", config)
+	if err != nil {
+		return nil, err
+	}
+	pipeline.Add(rtpbin)
+	if config.RTX {
+		if err := enableRTX(rtpbin, config, rtxSessionIDs...); err != nil {
+			return nil, err
+		}
+	}
+	if config.SRTPKey != nil {
+		if err := enableSRTP(rtpbin, config, sessionIDs...); err != nil {
+			return nil, err
 		}
 	}
 
+	if !config.NoVideo {
+		if err := addVideoBranch(pipeline, rtpbin, config); err != nil {
+			return nil, err
+		}
+	}
+	if !config.NoAudio {
+		if err := addAudioBranch(pipeline, rtpbin, config); err != nil {
+			return nil, err
+		}
+	}
+	if config.TelemetryRTP {
+		if err := addTelemetryBranch(pipeline, rtpbin, config); err != nil {
+			return nil, err
+		}
+	}
+	watchReceiverReports(rtpbin)
+
+	return pipeline, nil
+}
+
+// addVideoBranch adds the video capture, encode and payload chain to a
+// pipeline and sends it through the video session of rtpbin
+func addVideoBranch(pipeline *gst.Pipeline, rtpbin *gst.Element, config StreamConfig) error {
+	// Capture, convert and encode
+	elements, err := buildVideoEncodeChain(config)
+	if err != nil {
+		return err
+	}
+
+	// Branch the encoded video off to browser previews
+	if config.WebPreview != "" {
+		elements = append(elements, createPreviewTee()...)
+	}
+
+	// Add parser and payloader
+	parser, payloader, err := createVideoParserPayloader(config.Encoder, videoPayloaderName)
+	if err != nil {
+		return err
+	}
+	configurePayloader(payloader, config.SSRC, config.PayloadType)
+	if parser != nil {
+		elements = append(elements, parser)
+	}
+	elements = append(elements, payloader)
+
+	// Add forward error correction after the payloader
+	fecEnc, err := createFECEncoder(config)
+	if err != nil {
+		return err
+	}
+	if fecEnc != nil {
+		elements = append(elements, fecEnc)
+	}
+
+	// Add and link all elements
+	if err := addAndLinkElements(pipeline, elements); err != nil {
+		return err
+	}
+
+	// Link payloader (or FEC encoder) through rtpbin to the RTP/RTCP sinks
+	if err := linkRTPSession(pipeline, rtpbin, elements[len(elements)-1], videoSession(config), config.Destinations); err != nil {
+		return err
+	}
+	if fecEnc != nil {
+		if err := linkFECStreams(pipeline, fecEnc, config); err != nil {
+			return err
+		}
+	}
+
+	// Simulcast renditions encoded from the same capture
+	for i := range config.Renditions {
+		if err := addRenditionBranch(pipeline, rtpbin, config, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildVideoEncodeChain creates the video elements from the source up to and
+// including the encoder, shared by all output modes
+func buildVideoEncodeChain(config StreamConfig) ([]*gst.Element, error) {
+	// Create source element
+	src, err := createVideoSource(config, config.UseVideoTestSrc)
+	if err != nil {
+		return nil, err
+	}
+
 	// Build the rest of the pipeline
 	elements := []*gst.Element{src}
 
+	// Simulcast renditions branch off the capture before scaling
+	if len(config.Renditions) > 0 {
+		tee, _ := gst.NewElementWithName("tee", videoTeeName)
+		teeQueue, _ := gst.NewElement("queue")
+		teeQueue.SetProperty("max-size-buffers", 1)
+		teeQueue.SetProperty("leaky", 2) // downstream
+		elements = append(elements, tee, teeQueue)
+	}
+
 	// Add conversion elements (required on all platforms for format negotiation)
 	platform, _ := detectPlatform()
 	scale, _ := gst.NewElement("videoscale")
@@ -44,12 +202,21 @@ func BuildVideoPipeline(config StreamConfig) (*gst.Pipeline, error) {
 	elements = append(elements, scale, rate, convert)
 
 	// Add caps filter
-	capsFilter, _ := gst.NewElement("capsfilter")
+	capsFilter, _ := gst.NewElementWithName("capsfilter", videoCapsName)
 	capsStr := buildVideoCaps(config, platform)
 	caps := gst.NewCapsFromString(capsStr)
 	capsFilter.SetProperty("caps", caps)
 	elements = append(elements, capsFilter)
 
+	// On-screen display, drawn on raw frames before any hardware upload
+	if config.OSD.Enabled() {
+		overlays, err := createOSDOverlays(config)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, overlays...)
+	}
+
 	// Add queue
 	queue, _ := gst.NewElement("queue")
 	queue.SetProperty("max-size-buffers", 1)
@@ -66,40 +233,48 @@ func BuildVideoPipeline(config StreamConfig) (*gst.Pipeline, error) {
 	}
 
 	// Add encoder
-	encoder, err := createVideoEncoder(config.Encoder)
+	encoder, err := createVideoEncoder(config.Encoder, videoEncoderName)
 	if err != nil {
 		return nil, err
 	}
 	elements = append(elements, encoder)
 
-	// Add parser and payloader
-	parser, payloader, err := createVideoParserPayloader(config.Encoder)
-	if err != nil {
-		return nil, err
-	}
-	elements = append(elements, parser, payloader)
-
-	// Add UDP sink
-	udpSink, _ := gst.NewElement("udpsink")
-	udpSink.SetProperty("host", config.Host)
-	udpSink.SetProperty("port", config.Port)
-	udpSink.SetProperty("sync", false)
-	udpSink.SetProperty("async", false)
-	elements = append(elements, udpSink)
+	return elements, nil
+}
 
-	// Add all elements to pipeline
-	for _, elem := range elements {
-		pipeline.Add(elem)
-	}
+// Source element names, so that live controls can replace them
+const (
+	videoSourceName = "video-src"
+	audioSourceName = "audio-src"
+)
 
-	// Link all elements
-	for i := 0; i < len(elements)-1; i++ {
-		if err := elements[i].Link(elements[i+1]); err != nil {
-			return nil, fmt.Errorf("failed to link %s to %s: %w", elements[i].GetName(), elements[i+1].GetName(), err)
+// createVideoSource creates the test source or the selected video device
+func createVideoSource(config StreamConfig, test bool) (*gst.Element, error) {
+	if test {
+		src, err := gst.NewElementWithName("videotestsrc", videoSourceName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create videotestsrc: %w", err)
 		}
+		src.SetProperty("is-live", true)
+		src.SetProperty("pattern", "ball")
+		return src, nil
 	}
 
-	return pipeline, nil
+	if config.VideoDevice == nil {
+		return nil, fmt.Errorf("no video device selected")
+	}
+	// Use device.CreateElement() for real devices
+	src := config.VideoDevice.CreateElement(videoSourceName)
+	if src == nil {
+		return nil, fmt.Errorf("failed to create video device source")
+	}
+	// Set additional properties if not test source
+	platform, _ := detectPlatform()
+	if platform == "darwin" {
+		src.SetProperty("do-stats", true)
+		src.SetProperty("do-timestamp", true)
+	}
+	return src, nil
 }
 
 // BuildAudioPipeline builds an audio pipeline from elements
@@ -109,24 +284,64 @@ func BuildAudioPipeline(config StreamConfig) (*gst.Pipeline, error) {
 		return nil, fmt.Errorf("failed to create pipeline: %w", err)
 	}
 
-	// Create source element
-	var src *gst.Element
-	if config.UseAudioTestSrc {
-		src, err = gst.NewElement("audiotestsrc")
-		if err != nil {
-			return nil, fmt.Errorf("failed to create audiotestsrc: %w", err)
+	// RTP session management
+	rtpbin, err := newRTPBin("audio-rtpbin", config)
+	if err != nil {
+		return nil, err
+	}
+	pipeline.Add(rtpbin)
+	if config.SRTPKey != nil {
+		if err := enableSRTP(rtpbin, config, audioSessionID); err != nil {
+			return nil, err
 		}
-		src.SetProperty("is-live", true)
-		src.SetProperty("wave", "ticks")
-	} else {
-		// Use device.CreateElement() for real devices
-		src = config.AudioDevice.CreateElement("")
 	}
-	src.SetProperty("do-timestamp", true)
 
-	// Caps filter for audio format
+	if err := addAudioBranch(pipeline, rtpbin, config); err != nil {
+		return nil, err
+	}
+	watchReceiverReports(rtpbin)
+
+	return pipeline, nil
+}
+
+// addAudioBranch adds the audio capture, encode and payload chain to a
+// pipeline and sends it through the audio session of rtpbin
+func addAudioBranch(pipeline *gst.Pipeline, rtpbin *gst.Element, config StreamConfig) error {
+	// Capture, convert and encode
+	elements, err := buildAudioEncodeChain(config)
+	if err != nil {
+		return err
+	}
+
+	// RTP payloader
+	payloader, err := createAudioPayloader(config)
+	if err != nil {
+		return err
+	}
+
+	// Add and link all elements
+	elements = append(elements, payloader)
+	if err := addAndLinkElements(pipeline, elements); err != nil {
+		return err
+	}
+
+	// Link payloader through rtpbin to the RTP/RTCP sinks
+	return linkRTPSession(pipeline, rtpbin, payloader, audioSession(config), config.Destinations)
+}
+
+// buildAudioEncodeChain creates the audio elements from the source up to and
+// including the encoder (if any), shared by all output modes
+func buildAudioEncodeChain(config StreamConfig) ([]*gst.Element, error) {
+	// Create source element
+	src, err := createAudioSource(config, config.UseAudioTestSrc)
+	if err != nil {
+		return nil, err
+	}
+
+	// Caps filter for the encoder's rate and channels, after audioconvert and
+	// audioresample so any device format is converted to it
 	capsFilter, _ := gst.NewElement("capsfilter")
-	caps := gst.NewCapsFromString("audio/x-raw,rate=48000,channels=2")
+	caps := gst.NewCapsFromString(audioRawCaps(config.Audio))
 	capsFilter.SetProperty("caps", caps)
 
 	// Queues
@@ -144,38 +359,55 @@ func BuildAudioPipeline(config StreamConfig) (*gst.Pipeline, error) {
 	queue2.SetProperty("max-size-time", uint64(0))
 	queue2.SetProperty("max-size-bytes", 0)
 
-	// Opus encoder
-	opusEnc, _ := gst.NewElement("opusenc")
-	opusEnc.SetProperty("bitrate", 128000)
-	opusEnc.SetProperty("frame-size", 20)
-
-	// RTP payloader
-	rtpOpusPay, _ := gst.NewElement("rtpopuspay")
-
-	// UDP sink
-	udpSink, _ := gst.NewElement("udpsink")
-	udpSink.SetProperty("host", config.Host)
-	udpSink.SetProperty("port", config.AudioPort)
-	udpSink.SetProperty("sync", false)
-	udpSink.SetProperty("async", false)
+	// Encoder
+	encoder, err := createAudioEncoder(config.Audio)
+	if err != nil {
+		return nil, err
+	}
 
-	// Add all elements to pipeline
 	elements := []*gst.Element{
-		src, capsFilter, queue1, audioConvert, audioResample,
-		queue2, opusEnc, rtpOpusPay, udpSink,
+		src, queue1, audioConvert, audioResample, capsFilter,
+		queue2,
 	}
+	return append(elements, encoder...), nil
+}
+
+// createAudioSource creates the test source or the selected audio device
+func createAudioSource(config StreamConfig, test bool) (*gst.Element, error) {
+	var src *gst.Element
+	if test {
+		var err error
+		src, err = gst.NewElementWithName("audiotestsrc", audioSourceName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create audiotestsrc: %w", err)
+		}
+		src.SetProperty("is-live", true)
+		src.SetProperty("wave", "ticks")
+	} else {
+		if config.AudioDevice == nil {
+			return nil, fmt.Errorf("no audio device selected")
+		}
+		// Use device.CreateElement() for real devices
+		src = config.AudioDevice.CreateElement(audioSourceName)
+		if src == nil {
+			return nil, fmt.Errorf("failed to create audio device source")
+		}
+	}
+	src.SetProperty("do-timestamp", true)
+	return src, nil
+}
+
+// addAndLinkElements adds elements to a pipeline and links them in order
+func addAndLinkElements(pipeline *gst.Pipeline, elements []*gst.Element) error {
 	for _, elem := range elements {
 		pipeline.Add(elem)
 	}
-
-	// Link all elements
 	for i := 0; i < len(elements)-1; i++ {
 		if err := elements[i].Link(elements[i+1]); err != nil {
-			return nil, fmt.Errorf("failed to link %s to %s: %w", elements[i].GetName(), elements[i+1].GetName(), err)
+			return fmt.Errorf("failed to link %s to %s: %w", elements[i].GetName(), elements[i+1].GetName(), err)
 		}
 	}
-
-	return pipeline, nil
+	return nil
 }
 
 func buildVideoCaps(config StreamConfig, platform string) string {
@@ -188,9 +420,15 @@ func buildVideoCaps(config StreamConfig, platform string) string {
 		config.Resolution.Width, config.Resolution.Height, config.Framerate)
 }
 
-func createVideoEncoder(encoderType EncoderType) (*gst.Element, error) {
+// Names of the video encoder and payloader, so live controls can find them
+const (
+	videoEncoderName   = "video-encoder"
+	videoPayloaderName = "video-pay"
+)
+
+func createVideoEncoder(encoderType EncoderType, name string) (*gst.Element, error) {
 	encoderName := string(encoderType)
-	encoder, err := gst.NewElement(encoderName)
+	encoder, err := gst.NewElementWithName(encoderName, name)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create encoder %s: %w", encoderName, err)
 	}
@@ -202,31 +440,34 @@ func createVideoEncoder(encoderType EncoderType) (*gst.Element, error) {
 		if encoderType == VTEncH265HW {
 			encoder.SetProperty("allow-frame-reordering", false)
 		}
+	case X264Enc:
+		encoder.SetArg("tune", "zerolatency")
+		encoder.SetArg("speed-preset", "ultrafast")
 	}
 
 	return encoder, nil
 }
 
-func createVideoParserPayloader(encoderType EncoderType) (*gst.Element, *gst.Element, error) {
+func createVideoParserPayloader(encoderType EncoderType, payloaderName string) (*gst.Element, *gst.Element, error) {
 	codecFamily := GetCodecFamily(encoderType)
 
-	var parserName, payloaderName string
+	var parserName, payloaderFactory string
 	switch codecFamily {
 	case CodecH264:
 		parserName = "h264parse"
-		payloaderName = "rtph264pay"
+		payloaderFactory = "rtph264pay"
 	case CodecH265:
 		parserName = "h265parse"
-		payloaderName = "rtph265pay"
+		payloaderFactory = "rtph265pay"
 	case CodecVP8:
 		parserName = ""
-		payloaderName = "rtpvp8pay"
+		payloaderFactory = "rtpvp8pay"
 	case CodecVP9:
 		parserName = "vp9parse"
-		payloaderName = "rtpvp9pay"
+		payloaderFactory = "rtpvp9pay"
 	case CodecAV1:
 		parserName = "av1parse"
-		payloaderName = "rtpav1pay"
+		payloaderFactory = "rtpav1pay"
 	default:
 		return nil, nil, fmt.Errorf("unsupported codec family: %s", codecFamily)
 	}
@@ -240,9 +481,9 @@ func createVideoParserPayloader(encoderType EncoderType) (*gst.Element, *gst.Ele
 		}
 	}
 
-	payloader, err := gst.NewElement(payloaderName)
+	payloader, err := gst.NewElementWithName(payloaderFactory, payloaderName)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create payloader %s: %w", payloaderName, err)
+		return nil, nil, fmt.Errorf("failed to create payloader %s: %w", payloaderFactory, err)
 	}
 
 	// Set payloader properties
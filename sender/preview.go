@@ -0,0 +1,348 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/go-gst/go-glib/glib"
+	"github.com/go-gst/go-gst/gst"
+)
+
+//go:embed preview.html
+var previewPage []byte
+
+// previewMaxViewers bounds the number of browsers watching at once
+const previewMaxViewers = 4
+
+// signalMessage is a WebSocket signalling message exchanged with the preview page
+type signalMessage struct {
+	Type          string `json:"type"` // offer, answer, ice or error
+	SDP           string `json:"sdp,omitempty"`
+	Candidate     string `json:"candidate,omitempty"`
+	SDPMLineIndex uint   `json:"sdpMLineIndex"`
+	Error         string `json:"error,omitempty"`
+}
+
+// ValidatePreviewCodec checks that browsers can decode the codec
+func ValidatePreviewCodec(family CodecFamily) error {
+	switch family {
+	case CodecH264, CodecVP8, CodecVP9:
+		return nil
+	}
+	return fmt.Errorf("browsers cannot reliably decode %s, use an H.264, VP8 or VP9 encoder for --web-preview", family)
+}
+
+// ValidatePreviewAddress checks a listen address such as :8080
+func ValidatePreviewAddress(addr string) error {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("invalid web preview address %q, expected host:port or :port", addr)
+	}
+	return nil
+}
+
+// createPreviewTee creates the tee after the encoder that preview viewers
+// branch off. Unlinked branches are fine, viewers come and go.
+func createPreviewTee() []*gst.Element {
+	tee, _ := gst.NewElementWithName("tee", "preview-tee")
+	tee.SetProperty("allow-not-linked", true)
+	queue, _ := gst.NewElement("queue")
+	return []*gst.Element{tee, queue}
+}
+
+// describePreview describes the preview branches for the printed commands
+func describePreview(config StreamConfig) string {
+	return fmt.Sprintf("tee name=preview-tee after the encoder, one webrtcbin per viewer of http://%s", config.WebPreview)
+}
+
+// previewViewer is one browser watching the preview: a bin on the tee holding
+// its own parser, payloader and webrtcbin, so the encoder output is shared
+type previewViewer struct {
+	id     int
+	bin    *gst.Bin
+	teePad *gst.Pad
+	webrtc *gst.Element
+	ws     *wsConn
+
+	mu      sync.Mutex
+	offered bool
+	pending []signalMessage // Local candidates gathered before the offer was sent
+}
+
+// sendOffer sends the offer followed by the candidates gathered meanwhile, so
+// the browser never gets a candidate before the description it belongs to
+func (v *previewViewer) sendOffer(offer string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	sendSignal(v.ws, signalMessage{Type: "offer", SDP: offer})
+	for _, msg := range v.pending {
+		sendSignal(v.ws, msg)
+	}
+	v.pending = nil
+	v.offered = true
+}
+
+// sendCandidate trickles a local candidate, or queues it until the offer is sent
+func (v *previewViewer) sendCandidate(mline uint, candidate string) {
+	msg := signalMessage{Type: "ice", Candidate: candidate, SDPMLineIndex: mline}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.offered {
+		v.pending = append(v.pending, msg)
+		return
+	}
+	sendSignal(v.ws, msg)
+}
+
+// PreviewServer serves the preview page and connects each browser to a new
+// webrtcbin branch of the video pipeline
+type PreviewServer struct {
+	config   StreamConfig
+	pipeline *gst.Pipeline
+	tee      *gst.Element
+	listener net.Listener
+
+	mu      sync.Mutex
+	nextID  int
+	viewers map[int]*previewViewer
+}
+
+// NewPreviewServer creates a preview server for a video pipeline built with
+// config.WebPreview set
+func NewPreviewServer(config StreamConfig, pipeline *gst.Pipeline) (*PreviewServer, error) {
+	tee, err := pipeline.GetElementByName("preview-tee")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find preview tee: %w", err)
+	}
+	return &PreviewServer{
+		config:   config,
+		pipeline: pipeline,
+		tee:      tee,
+		viewers:  make(map[int]*previewViewer),
+	}, nil
+}
+
+// Start listens for browsers in the background
+func (s *PreviewServer) Start() error {
+	listener, err := net.Listen("tcp", s.config.WebPreview)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.config.WebPreview, err)
+	}
+	s.listener = listener
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(previewPage)
+	})
+	mux.HandleFunc("/ws", s.handleSignalling)
+	go http.Serve(listener, mux)
+
+	fmt.Printf("Web preview at http://%s\n", listener.Addr())
+	return nil
+}
+
+// Close stops accepting browsers and disconnects the current ones
+func (s *PreviewServer) Close() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, viewer := range s.viewers {
+		viewer.ws.Close()
+	}
+}
+
+// handleSignalling runs the WebSocket signalling of one browser: the sender
+// offers, the browser answers, and both trickle ICE candidates
+func (s *PreviewServer) handleSignalling(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		fmt.Printf("[preview] refused %s: %v\n", r.RemoteAddr, err)
+		return
+	}
+	defer ws.Close()
+
+	s.mu.Lock()
+	if len(s.viewers) >= previewMaxViewers {
+		s.mu.Unlock()
+		sendSignal(ws, signalMessage{Type: "error", Error: fmt.Sprintf("at most %d viewers", previewMaxViewers)})
+		return
+	}
+	s.nextID++
+	viewer := &previewViewer{id: s.nextID, ws: ws}
+	s.viewers[viewer.id] = viewer
+	s.mu.Unlock()
+
+	// Pipeline changes happen on the main loop
+	added := make(chan error, 1)
+	glib.IdleAdd(func() bool {
+		added <- s.addViewer(viewer)
+		return false
+	})
+	if err := <-added; err != nil {
+		fmt.Printf("[preview] viewer %d: %v\n", viewer.id, err)
+		sendSignal(ws, signalMessage{Type: "error", Error: err.Error()})
+		s.removeViewer(viewer)
+		return
+	}
+	fmt.Printf("[preview] viewer %d connected from %s\n", viewer.id, r.RemoteAddr)
+
+	for {
+		data, err := ws.ReadMessage()
+		if err != nil {
+			break
+		}
+		var msg signalMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		switch msg.Type {
+		case "answer":
+			if err := setRemoteAnswer(viewer.webrtc, msg.SDP); err != nil {
+				fmt.Printf("[preview] viewer %d: %v\n", viewer.id, err)
+			}
+		case "ice":
+			if msg.Candidate != "" {
+				viewer.webrtc.Emit("add-ice-candidate", msg.SDPMLineIndex, msg.Candidate)
+			}
+		}
+	}
+
+	s.removeViewer(viewer)
+	fmt.Printf("[preview] viewer %d disconnected\n", viewer.id)
+}
+
+// addViewer builds the viewer's branch and links it to the tee. On failure
+// nothing of the branch is left in the pipeline. Runs on the main loop.
+func (s *PreviewServer) addViewer(viewer *previewViewer) error {
+	prefix := fmt.Sprintf("preview-%d", viewer.id)
+
+	// The queue gives each viewer its own streaming thread off the tee.
+	// Encoded frames depend on each other, so it must not leak.
+	queue, _ := gst.NewElement("queue")
+	parser, payloader, err := createVideoParserPayloader(s.config.Encoder, videoPayloaderName)
+	if err != nil {
+		return err
+	}
+	payloader.SetProperty("name", prefix+"-pay")
+	configurePayloader(payloader, 0, s.config.PayloadType)
+	webrtc, err := newWebRTCBin(prefix+"-webrtc", s.config.STUNServer)
+	if err != nil {
+		return err
+	}
+
+	elements := []*gst.Element{queue}
+	if parser != nil {
+		elements = append(elements, parser)
+	}
+	elements = append(elements, payloader, newRTPCapsFilter(webrtcVideoCaps(s.config)))
+
+	bin := gst.NewBin(prefix)
+	inPipeline := false
+	fail := func(err error) error {
+		bin.SetState(gst.StateNull)
+		if inPipeline {
+			s.pipeline.Remove(bin.Element)
+		}
+		return err
+	}
+	for _, elem := range append(elements, webrtc) {
+		if err := bin.Add(elem); err != nil {
+			return fail(fmt.Errorf("failed to add %s to the preview branch: %w", elem.GetName(), err))
+		}
+	}
+	for i := 0; i < len(elements)-1; i++ {
+		if err := elements[i].Link(elements[i+1]); err != nil {
+			return fail(fmt.Errorf("failed to link %s to %s: %w", elements[i].GetName(), elements[i+1].GetName(), err))
+		}
+	}
+	if err := linkSendOnly(elements[len(elements)-1], webrtc, "sink_0"); err != nil {
+		return fail(err)
+	}
+	ghost := gst.NewGhostPad("sink", queue.GetStaticPad("sink"))
+	bin.AddPad(ghost.ProxyPad.Pad)
+
+	// Offer once webrtcbin is ready; the offer blocks, so it runs off the main loop
+	var once sync.Once
+	webrtc.Connect("on-negotiation-needed", func(self *gst.Element) {
+		once.Do(func() {
+			go func() {
+				offer, err := createOffer(self)
+				if err != nil {
+					fmt.Printf("[preview] viewer %d: %v\n", viewer.id, err)
+					viewer.ws.Close()
+					return
+				}
+				viewer.sendOffer(offer)
+			}()
+		})
+	})
+	webrtc.Connect("on-ice-candidate", func(self *gst.Element, mline uint, candidate string) {
+		viewer.sendCandidate(mline, candidate)
+	})
+
+	// Bring the branch up before linking so the tee never pushes into a stopped bin
+	if err := s.pipeline.Add(bin.Element); err != nil {
+		return fail(fmt.Errorf("failed to add the preview branch: %w", err))
+	}
+	inPipeline = true
+	if !bin.SyncStateWithParent() {
+		return fail(fmt.Errorf("failed to start the preview branch"))
+	}
+	teePad := s.tee.GetRequestPad("src_%u")
+	if teePad == nil {
+		return fail(fmt.Errorf("failed to request a preview tee pad"))
+	}
+	if ret := teePad.Link(ghost.ProxyPad.Pad); ret != gst.PadLinkOK {
+		s.tee.ReleaseRequestPad(teePad)
+		return fail(fmt.Errorf("failed to link preview branch: %s", ret))
+	}
+	viewer.bin = bin
+	viewer.teePad = teePad
+	viewer.webrtc = webrtc
+
+	// Start the new viewer on a keyframe instead of waiting for the next one
+	teePad.SendEvent(newForceKeyUnitEvent())
+	return nil
+}
+
+// removeViewer unlinks and disposes of the viewer's branch
+func (s *PreviewServer) removeViewer(viewer *previewViewer) {
+	s.mu.Lock()
+	delete(s.viewers, viewer.id)
+	s.mu.Unlock()
+
+	if viewer.bin == nil {
+		return
+	}
+	glib.IdleAdd(func() bool {
+		viewer.teePad.Unlink(viewer.bin.GetStaticPad("sink"))
+		s.tee.ReleaseRequestPad(viewer.teePad)
+		viewer.bin.SetState(gst.StateNull)
+		s.pipeline.Remove(viewer.bin.Element)
+		return false
+	})
+}
+
+// newForceKeyUnitEvent creates the upstream event asking the encoder for a
+// keyframe with its parameter sets (GstForceKeyUnit)
+func newForceKeyUnitEvent() *gst.Event {
+	structure := gst.NewStructure("GstForceKeyUnit")
+	structure.SetValue("all-headers", true)
+	return gst.NewCustomEvent(gst.EventTypeCustomUpstream, structure)
+}
+
+// sendSignal sends a signalling message, ignoring errors from closed connections
+func sendSignal(ws *wsConn, msg signalMessage) {
+	data, _ := json.Marshal(msg)
+	ws.WriteMessage(data)
+}
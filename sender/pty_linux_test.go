@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// openPTY opens a pseudo-terminal pair and returns the master and the path of
+// the slave, for openSerial to open like a flight controller's serial port.
+// The master is closed when the test ends.
+func openPTY(t *testing.T) (*os.File, string) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+
+	// SyscallConn keeps the master non-blocking, so closing it ends a pending read
+	conn, err := master.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index uint32
+	var errno syscall.Errno
+	conn.Control(func(fd uintptr) {
+		unlock := int32(0)
+		if _, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+			return
+		}
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&index)))
+	})
+	if errno != 0 {
+		t.Fatalf("failed to set up the pty: %v", errno)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", index)
+}
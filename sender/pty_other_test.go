@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// openPTY skips the test, pseudo-terminals are only set up on Linux
+func openPTY(t *testing.T) (*os.File, string) {
+	t.Skip("pseudo-terminal tests run on Linux only")
+	return nil, ""
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-gst/go-glib/glib"
+	"github.com/go-gst/go-gst/gst"
+)
+
+// RTP session IDs within rtpbin
+const (
+	videoSessionID     = 0
+	audioSessionID     = 1
+	telemetrySessionID = 2
+)
+
+// Default RTP payload types
+const (
+	defaultVideoPayloadType     = 96
+	defaultAudioPayloadType     = 97
+	defaultTelemetryPayloadType = 98
+)
+
+// rtpSession describes the ports of one RTP session (video, audio or telemetry)
+type rtpSession struct {
+	ID       int
+	Label    string                // "video", "audio" or "telemetry", used for element names
+	RTPPort  func(Destination) int // Destination port for RTP
+	RTCPPort func(Destination) int // Destination port for RTCP sender reports
+	RecvPort int                   // Local port receiving RTCP receiver reports (0 = disabled)
+	Loss     float64               // Simulated packet loss probability (testing only)
+	SRTP     bool                  // Incoming RTCP is encrypted (SRTCP)
+}
+
+// videoSession returns the video RTP session for a config
+func videoSession(config StreamConfig) rtpSession {
+	return rtpSession{
+		ID:       videoSessionID,
+		Label:    "video",
+		RTPPort:  videoPort,
+		RTCPPort: videoRTCPPort,
+		RecvPort: config.RTCPRecvPort,
+		Loss:     config.SimulateLoss,
+		SRTP:     config.SRTPKey != nil,
+	}
+}
+
+// audioSession returns the audio RTP session for a config
+func audioSession(config StreamConfig) rtpSession {
+	recvPort := 0
+	if config.RTCPRecvPort > 0 {
+		recvPort = config.RTCPRecvPort + 1
+	}
+	return rtpSession{
+		ID:       audioSessionID,
+		Label:    "audio",
+		RTPPort:  audioPort,
+		RTCPPort: audioRTCPPort,
+		RecvPort: recvPort,
+		Loss:     config.SimulateLoss,
+		SRTP:     config.SRTPKey != nil,
+	}
+}
+
+// telemetrySession returns the telemetry RTP session for a config. Samples are
+// superseded by the next one, so the ground station sends no receiver reports
+// for it and nothing is retransmitted.
+func telemetrySession(config StreamConfig) rtpSession {
+	return rtpSession{
+		ID:       telemetrySessionID,
+		Label:    "telemetry",
+		RTPPort:  telemetryPort,
+		RTCPPort: telemetryRTCPPort,
+		Loss:     config.SimulateLoss,
+		SRTP:     config.SRTPKey != nil,
+	}
+}
+
+// newRTPBin creates an rtpbin carrying the configured CNAME in its RTCP SDES
+func newRTPBin(name string, config StreamConfig) (*gst.Element, error) {
+	rtpbin, err := gst.NewElementWithName("rtpbin", name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rtpbin: %w", err)
+	}
+	if config.CNAME != "" {
+		sdes := gst.NewStructureFromString(buildSDES(config.CNAME))
+		rtpbin.SetProperty("sdes", sdes)
+	}
+	return rtpbin, nil
+}
+
+// buildSDES builds the rtpbin sdes structure string for a CNAME
+func buildSDES(cname string) string {
+	return fmt.Sprintf("application/x-rtp-source-sdes,cname=(string)%q", cname)
+}
+
+// audioSSRC returns the SSRC of the audio stream, derived from the video SSRC
+// so that both can be set with a single flag (0 = random)
+func audioSSRC(config StreamConfig) uint32 {
+	if config.SSRC == 0 {
+		return 0
+	}
+	return config.SSRC + 1
+}
+
+// telemetrySSRC returns the SSRC of the telemetry stream, derived from the
+// video SSRC like the audio one (0 = random)
+func telemetrySSRC(config StreamConfig) uint32 {
+	if config.SSRC == 0 {
+		return 0
+	}
+	return config.SSRC + 2
+}
+
+// configurePayloader applies the SSRC and payload type to an RTP payloader
+func configurePayloader(payloader *gst.Element, ssrc uint32, pt int) {
+	if ssrc != 0 {
+		payloader.SetProperty("ssrc", uint(ssrc))
+	}
+	payloader.SetProperty("pt", uint(pt))
+}
+
+// linkRTPSession links a payloader through an rtpbin session to a multiudpsink
+// for RTP, a multiudpsink for RTCP sender reports, and an optional udpsrc that
+// feeds RTCP receiver reports from the ground station back into the session.
+func linkRTPSession(pipeline *gst.Pipeline, rtpbin *gst.Element, payloader *gst.Element, session rtpSession, dests []Destination) error {
+	// RTP sink (one client per destination)
+	rtpSink, _ := gst.NewElementWithName("multiudpsink", session.Label+"-sink")
+	rtpSink.SetProperty("clients", buildClients(dests, session.RTPPort))
+	rtpSink.SetProperty("sync", false)
+	rtpSink.SetProperty("async", false)
+
+	// RTCP sink, must not sync or preroll since RTCP is sent on its own schedule
+	rtcpSink, _ := gst.NewElementWithName("multiudpsink", session.Label+"-rtcp-sink")
+	rtcpSink.SetProperty("clients", buildClients(dests, session.RTCPPort))
+	rtcpSink.SetProperty("sync", false)
+	rtcpSink.SetProperty("async", false)
+
+	pipeline.AddMany(rtpSink, rtcpSink)
+
+	if err := linkPads(payloader, "src", rtpbin, fmt.Sprintf("send_rtp_sink_%d", session.ID)); err != nil {
+		return err
+	}
+	if session.Loss > 0 {
+		// Drop packets between rtpbin and the sink to test FEC/RTX on loopback
+		netsim, err := gst.NewElementWithName("netsim", session.Label+"-netsim")
+		if err != nil {
+			return fmt.Errorf("failed to create netsim: %w", err)
+		}
+		netsim.SetProperty("drop-probability", float32(session.Loss))
+		pipeline.Add(netsim)
+		if err := linkPads(rtpbin, fmt.Sprintf("send_rtp_src_%d", session.ID), netsim, "sink"); err != nil {
+			return err
+		}
+		if err := netsim.Link(rtpSink); err != nil {
+			return fmt.Errorf("failed to link %s to %s: %w", netsim.GetName(), rtpSink.GetName(), err)
+		}
+	} else if err := linkPads(rtpbin, fmt.Sprintf("send_rtp_src_%d", session.ID), rtpSink, "sink"); err != nil {
+		return err
+	}
+	if err := linkPads(rtpbin, fmt.Sprintf("send_rtcp_src_%d", session.ID), rtcpSink, "sink"); err != nil {
+		return err
+	}
+
+	// RTCP receiver reports from the ground station
+	if session.RecvPort > 0 {
+		rtcpSrc, _ := gst.NewElementWithName("udpsrc", session.Label+"-rtcp-src")
+		rtcpSrc.SetProperty("port", session.RecvPort)
+		rtcpCaps := "application/x-rtcp"
+		if session.SRTP {
+			rtcpCaps = "application/x-srtcp"
+		}
+		rtcpSrc.SetProperty("caps", gst.NewCapsFromString(rtcpCaps))
+		pipeline.Add(rtcpSrc)
+		if err := linkPads(rtcpSrc, "src", rtpbin, fmt.Sprintf("recv_rtcp_sink_%d", session.ID)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// linkPads links a named pad of src to a named pad of sink, requesting
+// request pads (e.g. rtpbin send_rtp_sink_%u) when they do not exist yet
+func linkPads(src *gst.Element, srcPadName string, sink *gst.Element, sinkPadName string) error {
+	srcPad := getPad(src, srcPadName)
+	if srcPad == nil {
+		return fmt.Errorf("failed to get pad %s:%s", src.GetName(), srcPadName)
+	}
+	sinkPad := getPad(sink, sinkPadName)
+	if sinkPad == nil {
+		return fmt.Errorf("failed to get pad %s:%s", sink.GetName(), sinkPadName)
+	}
+	if ret := srcPad.Link(sinkPad); ret != gst.PadLinkOK {
+		return fmt.Errorf("failed to link %s:%s to %s:%s: %s", src.GetName(), srcPadName, sink.GetName(), sinkPadName, ret)
+	}
+	return nil
+}
+
+// getPad returns an existing pad or requests a new one
+func getPad(elem *gst.Element, name string) *gst.Pad {
+	if pad := elem.GetStaticPad(name); pad != nil {
+		return pad
+	}
+	return elem.GetRequestPad(name)
+}
+
+// watchReceiverReports logs packet loss, jitter and round-trip time from RTCP
+// receiver reports sent by the ground station
+func watchReceiverReports(rtpbin *gst.Element) {
+	rtpbin.Connect("on-ssrc-active", func(self *gst.Element, sessionID uint, ssrc uint) {
+		stats := getSourceStats(self, sessionID, ssrc)
+		if stats == nil {
+			return
+		}
+		values := stats.Values()
+		if haveRB, _ := values["have-rb"].(bool); !haveRB {
+			return
+		}
+
+		fractionLost, _ := toInt(values["rb-fractionlost"])
+		packetsLost, _ := toInt(values["rb-packetslost"])
+		jitter, _ := toInt(values["rb-jitter"])
+		roundTrip, _ := toInt(values["rb-round-trip"])
+
+		fmt.Printf("[rtcp] %s receiver %08x: loss %.1f%% (%d lost), jitter %d, rtt %.1fms\n",
+			sessionLabel(sessionID), ssrc,
+			float64(fractionLost)*100/256, packetsLost, jitter,
+			float64(roundTrip)*1000/65536)
+	})
+}
+
+// getSourceStats returns the stats structure of an SSRC in an rtpbin session
+func getSourceStats(rtpbin *gst.Element, sessionID uint, ssrc uint) *gst.Structure {
+	sessionObj, err := rtpbin.Emit("get-internal-session", sessionID)
+	if err != nil || sessionObj == nil {
+		return nil
+	}
+	session, ok := sessionObj.(*glib.Object)
+	if !ok {
+		return nil
+	}
+	sourceObj, err := session.Emit("get-source-by-ssrc", ssrc)
+	if err != nil || sourceObj == nil {
+		return nil
+	}
+	source, ok := sourceObj.(*glib.Object)
+	if !ok {
+		return nil
+	}
+	statsVal, err := source.GetProperty("stats")
+	if err != nil {
+		return nil
+	}
+	stats, _ := statsVal.(*gst.Structure)
+	return stats
+}
+
+// sessionLabel returns a human readable name for an rtpbin session ID
+func sessionLabel(sessionID uint) string {
+	switch sessionID {
+	case videoSessionID:
+		return "video"
+	case audioSessionID:
+		return "audio"
+	case telemetrySessionID:
+		return "telemetry"
+	}
+	if sessionID >= firstRenditionSessionID {
+		return fmt.Sprintf("rendition%d", sessionID-firstRenditionSessionID+1)
+	}
+	return fmt.Sprintf("session %d", sessionID)
+}
+
+// buildRTPBinCommand returns the gst-launch fragment declaring the rtpbin
+func buildRTPBinCommand(name string, config StreamConfig) string {
+	cmd := "rtpbin name=" + name
+	if config.RTX {
+		cmd += " rtp-profile=avpf"
+	}
+	if config.CNAME != "" {
+		cmd += fmt.Sprintf(" sdes=\"%s\"", strings.ReplaceAll(buildSDES(config.CNAME), `"`, `\"`))
+	}
+	return cmd
+}
+
+// buildRTPSessionCommand returns the gst-launch fragments linking an rtpbin
+// session to its RTP/RTCP sinks and RTCP source
+func buildRTPSessionCommand(name string, session rtpSession, dests []Destination) []string {
+	netsim := ""
+	if session.Loss > 0 {
+		netsim = fmt.Sprintf("netsim drop-probability=%g ! ", session.Loss)
+	}
+	fragments := []string{
+		fmt.Sprintf("%s.send_rtp_src_%d ! %smultiudpsink clients=%s sync=false async=false",
+			name, session.ID, netsim, buildClients(dests, session.RTPPort)),
+		fmt.Sprintf("%s.send_rtcp_src_%d ! multiudpsink clients=%s sync=false async=false",
+			name, session.ID, buildClients(dests, session.RTCPPort)),
+	}
+	if session.RecvPort > 0 {
+		fragments = append(fragments, fmt.Sprintf("udpsrc port=%d caps=application/x-rtcp ! %s.recv_rtcp_sink_%d",
+			session.RecvPort, name, session.ID))
+	}
+	return fragments
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-gst/go-glib/glib"
+	"github.com/go-gst/go-gst/examples"
+	"github.com/go-gst/go-gst/gst"
+)
+
+// Default RTSP server settings
+const (
+	defaultRTSPListen = "0.0.0.0:8554"
+	defaultRTSPPath   = "/fpv"
+)
+
+// RTSPConfig holds the settings of the serve-rtsp mode
+type RTSPConfig struct {
+	Address  string
+	Port     int
+	Path     string
+	User     string
+	Password string
+}
+
+// parseRTSPConfig validates the RTSP listen address, mount path and credentials
+func parseRTSPConfig(listen, path, auth string) (RTSPConfig, error) {
+	host, portStr, err := net.SplitHostPort(listen)
+	if err != nil {
+		return RTSPConfig{}, fmt.Errorf("expected listen address host:port, got: %s", listen)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return RTSPConfig{}, fmt.Errorf("invalid RTSP port: %s", portStr)
+	}
+	if host == "" {
+		host = "0.0.0.0"
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	config := RTSPConfig{Address: host, Port: port, Path: path}
+	if auth != "" {
+		user, password, ok := strings.Cut(auth, ":")
+		if !ok || user == "" {
+			return RTSPConfig{}, fmt.Errorf("expected credentials user:password")
+		}
+		config.User, config.Password = user, password
+	}
+	return config, nil
+}
+
+// URL returns the rtsp:// URL clients connect to
+func (c RTSPConfig) URL() string {
+	return fmt.Sprintf("rtsp://%s%s", net.JoinHostPort(c.Address, strconv.Itoa(c.Port)), c.Path)
+}
+
+// BuildRTSPLaunch builds the media factory description from the same capture
+// and encode chains as the UDP pipelines. The server payloads RTP itself and
+// expects the payloaders named pay0, pay1, ...
+func BuildRTSPLaunch(config StreamConfig) string {
+	var chains [][]string
+	if !config.NoVideo {
+		chains = append(chains, buildVideoChainCommand(config))
+	}
+	if !config.NoAudio {
+		chains = append(chains, buildAudioChainCommand(config))
+	}
+	streams := make([]string, len(chains))
+	for i, chain := range chains {
+		chain[len(chain)-1] += fmt.Sprintf(" name=pay%d", i)
+		streams[i] = strings.Join(chain, " ! ")
+	}
+	return fmt.Sprintf("( %s )", strings.Join(streams, " "))
+}
+
+// RunRTSPServer initializes GStreamer and serves the stream over RTSP until interrupted
+func RunRTSPServer(config StreamConfig, rtsp RTSPConfig) error {
+	gst.Init(nil)
+
+	if err := selectDevices(&config); err != nil {
+		return err
+	}
+
+	launch := BuildRTSPLaunch(config)
+	fmt.Println("RTSP media:")
+	fmt.Println(launch)
+	fmt.Println()
+
+	var runErr error
+	examples.RunLoop(func(mainLoop *glib.MainLoop) error {
+		server := NewRTSPServer(rtsp.Address, rtsp.Port, rtsp.Path, launch, rtsp.User, rtsp.Password)
+		if err := server.Attach(); err != nil {
+			runErr = err
+			return err
+		}
+		fmt.Printf("Serving %s\n", rtsp.URL())
+		if rtsp.User != "" {
+			fmt.Printf("Basic auth enabled for user %s\n", rtsp.User)
+		}
+		fmt.Println("Press Ctrl+C to stop.")
+
+		runErr = mainLoop.RunError()
+		return runErr
+	})
+	return runErr
+}
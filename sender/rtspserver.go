@@ -0,0 +1,83 @@
+package main
+
+/*
+#cgo pkg-config: gstreamer-rtsp-server-1.0
+#include <stdlib.h>
+#include <gst/rtsp-server/rtsp-server.h>
+
+// The token and role APIs are variadic, which cgo cannot call directly
+static void fpv_rtsp_add_basic_auth(GstRTSPServer *server, GstRTSPMediaFactory *factory,
+                                    const gchar *user, const gchar *password) {
+	GstRTSPAuth *auth = gst_rtsp_auth_new();
+	GstRTSPToken *token = gst_rtsp_token_new(GST_RTSP_TOKEN_MEDIA_FACTORY_ROLE, G_TYPE_STRING, user, NULL);
+	gchar *basic = gst_rtsp_auth_make_basic(user, password);
+
+	gst_rtsp_auth_add_basic(auth, basic, token);
+	gst_rtsp_server_set_auth(server, auth);
+	gst_rtsp_media_factory_add_role(factory, user,
+		GST_RTSP_PERM_MEDIA_FACTORY_ACCESS, G_TYPE_BOOLEAN, TRUE,
+		GST_RTSP_PERM_MEDIA_FACTORY_CONSTRUCT, G_TYPE_BOOLEAN, TRUE, NULL);
+
+	g_free(basic);
+	gst_rtsp_token_unref(token);
+	g_object_unref(auth);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"strconv"
+	"unsafe"
+)
+
+// RTSPServer is a minimal binding of gst-rtsp-server serving one mount point
+// whose media is built from a gst-launch description
+type RTSPServer struct {
+	server *C.GstRTSPServer
+}
+
+// NewRTSPServer creates an RTSP server on address:port serving launch at path.
+// Shared media makes all clients reuse one pipeline, so the encoder runs once.
+// Basic auth is enabled when user is not empty.
+func NewRTSPServer(address string, port int, path, launch, user, password string) *RTSPServer {
+	server := C.gst_rtsp_server_new()
+
+	cAddress := C.CString(address)
+	defer C.free(unsafe.Pointer(cAddress))
+	C.gst_rtsp_server_set_address(server, (*C.gchar)(cAddress))
+	cService := C.CString(strconv.Itoa(port))
+	defer C.free(unsafe.Pointer(cService))
+	C.gst_rtsp_server_set_service(server, (*C.gchar)(cService))
+
+	factory := C.gst_rtsp_media_factory_new()
+	cLaunch := C.CString(launch)
+	defer C.free(unsafe.Pointer(cLaunch))
+	C.gst_rtsp_media_factory_set_launch(factory, (*C.gchar)(cLaunch))
+	C.gst_rtsp_media_factory_set_shared(factory, C.gboolean(1))
+
+	if user != "" {
+		cUser := C.CString(user)
+		defer C.free(unsafe.Pointer(cUser))
+		cPassword := C.CString(password)
+		defer C.free(unsafe.Pointer(cPassword))
+		C.fpv_rtsp_add_basic_auth(server, factory, (*C.gchar)(cUser), (*C.gchar)(cPassword))
+	}
+
+	// The mount points take ownership of the factory
+	mounts := C.gst_rtsp_server_get_mount_points(server)
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	C.gst_rtsp_mount_points_add_factory(mounts, (*C.gchar)(cPath), factory)
+	C.g_object_unref(C.gpointer(unsafe.Pointer(mounts)))
+
+	return &RTSPServer{server: server}
+}
+
+// Attach starts listening on the default GLib main context
+func (s *RTSPServer) Attach() error {
+	if id := C.gst_rtsp_server_attach(s.server, nil); id == 0 {
+		return fmt.Errorf("failed to start RTSP server (port in use?)")
+	}
+	return nil
+}
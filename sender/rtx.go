@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-gst/go-glib/glib"
+	"github.com/go-gst/go-gst/gst"
+)
+
+// RTX payload types, one per RTP session (RFC 4588 requires a distinct type)
+const (
+	videoRTXPayloadType = 123
+	audioRTXPayloadType = 124
+)
+
+// defaultRTXHistory is how long sent packets are kept for retransmission
+const defaultRTXHistory = time.Second
+
+// rtpProfileAVPF is GST_RTP_PROFILE_AVPF, needed for rtpbin to act on NACK feedback
+const rtpProfileAVPF = 3
+
+// noElement returns NULL from a signal handler. go-gst cannot marshal a nil
+// *gst.Element, but wraps a nil instance into a NULL GValue.
+var noElement = &gst.Element{Object: &gst.Object{InitiallyUnowned: &glib.InitiallyUnowned{Object: &glib.Object{}}}}
+
+// enableRTX makes rtpbin insert an rtprtxsend into each of the given send
+// sessions. Receivers request lost packets with RTCP NACKs and get them back on
+// the RTX payload type. rtpbin asks for an aux sender in every session, the
+// others get none. Must be called before any send pads are requested.
+func enableRTX(rtpbin *gst.Element, config StreamConfig, sessionIDs ...uint) error {
+	// Build the aux senders up front so a missing plugin fails the pipeline
+	// build instead of the signal handler
+	senders := make(map[uint]*gst.Element, len(sessionIDs))
+	for _, id := range sessionIDs {
+		sender, err := newRTXSender(id, config)
+		if err != nil {
+			return err
+		}
+		senders[id] = sender
+	}
+
+	rtpbin.SetProperty("rtp-profile", rtpProfileAVPF)
+	rtpbin.Connect("request-aux-sender", func(self *gst.Element, sessionID uint) *gst.Element {
+		if sender, ok := senders[sessionID]; ok {
+			return sender
+		}
+		return noElement
+	})
+	return nil
+}
+
+// newRTXSender builds the aux sender bin for rtpbin, with ghost pads named after
+// the session as rtpbin expects (sink_%u / src_%u)
+func newRTXSender(sessionID uint, config StreamConfig) (*gst.Element, error) {
+	bin := gst.NewBin(fmt.Sprintf("rtx-sender-%d", sessionID))
+
+	pt, rtxPT := config.PayloadType, videoRTXPayloadType
+	if sessionID == audioSessionID {
+		pt, rtxPT = audioPayloadType(config.Audio), audioRTXPayloadType
+	}
+	rtx, err := gst.NewElementWithName("rtprtxsend", rtxElementName(sessionID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rtprtxsend: %w", err)
+	}
+	ptMap := gst.NewStructureFromString(fmt.Sprintf("application/x-rtp-pt-map,%d=(uint)%d", pt, rtxPT))
+	rtx.SetProperty("payload-type-map", ptMap)
+	rtx.SetProperty("max-size-time", uint(config.RTXHistory.Milliseconds()))
+	rtx.SetProperty("max-size-packets", uint(0))
+	bin.Add(rtx)
+
+	sinkPad := gst.NewGhostPad(fmt.Sprintf("sink_%d", sessionID), rtx.GetStaticPad("sink"))
+	srcPad := gst.NewGhostPad(fmt.Sprintf("src_%d", sessionID), rtx.GetStaticPad("src"))
+	bin.AddPad(sinkPad.Pad)
+	bin.AddPad(srcPad.Pad)
+
+	return bin.Element, nil
+}
+
+// rtxElementName returns the name of the rtprtxsend of an RTP session
+func rtxElementName(sessionID uint) string {
+	return fmt.Sprintf("%s-rtx", sessionLabel(sessionID))
+}
+
+// rtxStats returns a stats source reporting NACK requests and retransmitted
+// packets of an RTP session's rtprtxsend
+func rtxStats(pipeline *gst.Pipeline, sessionID uint) func() map[string]any {
+	return func() map[string]any {
+		rtx, err := pipeline.GetElementByNameRecursive(rtxElementName(sessionID))
+		if err != nil || rtx == nil {
+			return nil
+		}
+		requests, _ := rtx.GetProperty("num-rtx-requests")
+		packets, _ := rtx.GetProperty("num-rtx-packets")
+		return map[string]any{
+			"requests": requests,
+			"packets":  packets,
+		}
+	}
+}
+
+// describeRTX describes the RTX setup, which the printed gst-launch command
+// cannot express since it is attached through rtpbin's request-aux-sender signal
+func describeRTX(config StreamConfig) string {
+	return fmt.Sprintf("rtprtxsend payload-type-map=\"application/x-rtp-pt-map,%d=(uint)%d\" max-size-time=%d (attached via rtpbin request-aux-sender)",
+		config.PayloadType, videoRTXPayloadType, config.RTXHistory.Milliseconds())
+}
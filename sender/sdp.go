@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+)
+
+// rtpClockRate is the RTP clock rate of all video encodings
+const rtpClockRate = 90000
+
+// opusClockRate is the RTP clock rate of Opus (RFC 7587), regardless of input rate
+const opusClockRate = 48000
+
+// sdpVideoFmtpKeys lists the payloader caps fields that belong in a=fmtp, in order
+var sdpVideoFmtpKeys = map[CodecFamily][]string{
+	CodecH264: {"packetization-mode", "profile-level-id", "sprop-parameter-sets"},
+	CodecH265: {"profile-id", "tier-flag", "level-id", "sprop-vps", "sprop-sps", "sprop-pps"},
+}
+
+// SDPWriter writes SDP files describing the streams of a config, one per
+// destination and one per simulcast rendition. Files are rewritten when the
+// video caps change, e.g. when the parser has produced the H.264/H.265
+// parameter sets.
+type SDPWriter struct {
+	mu            sync.Mutex
+	path          string
+	config        StreamConfig
+	sessionID     int64
+	version       int64
+	videoCaps     map[string]any   // Latest caps of the main video payloader
+	renditionCaps []map[string]any // Latest caps of each rendition payloader
+}
+
+// NewSDPWriter creates an SDP writer for path
+func NewSDPWriter(path string, config StreamConfig) *SDPWriter {
+	now := time.Now().Unix()
+	return &SDPWriter{path: path, config: config, sessionID: now, version: now,
+		renditionCaps: make([]map[string]any, len(config.Renditions))}
+}
+
+// Write writes the SDP files, using fmtp parameters from the negotiated video
+// payloader caps when available (nil before the stream starts)
+func (w *SDPWriter) Write(videoCaps map[string]any) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if videoCaps != nil {
+		w.videoCaps = videoCaps
+	}
+	return w.write()
+}
+
+// write writes all SDP files with the latest caps. Must be called with mu held.
+func (w *SDPWriter) write() error {
+	w.version++
+	mode := sdpFileMode(w.config)
+	for i, dest := range w.config.Destinations {
+		sdp := BuildSDP(w.config, dest, w.videoCaps, w.sessionID, w.version)
+		if err := writeFileAtomic(sdpPath(w.path, i), []byte(sdp), mode); err != nil {
+			return err
+		}
+	}
+	for i := range w.config.Renditions {
+		sdp := BuildRenditionSDP(w.config, i, w.renditionCaps[i], w.sessionID, w.version)
+		if err := writeFileAtomic(sdpRenditionPath(w.path, i), []byte(sdp), mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Watch rewrites the SDP files whenever the caps on the main video payloader's
+// src pad change
+func (w *SDPWriter) Watch(payloader *gst.Element) {
+	w.watch(payloader, func(caps map[string]any) { w.videoCaps = caps })
+}
+
+// WatchRendition rewrites the SDP files whenever the caps on the payloader of
+// the i-th rendition change
+func (w *SDPWriter) WatchRendition(payloader *gst.Element, index int) {
+	w.watch(payloader, func(caps map[string]any) { w.renditionCaps[index] = caps })
+}
+
+// watch stores the caps of a payloader with store, under mu, and rewrites the files
+func (w *SDPWriter) watch(payloader *gst.Element, store func(caps map[string]any)) {
+	pad := payloader.GetStaticPad("src")
+	pad.Connect("notify::caps", func(self *gst.Pad) {
+		caps := self.GetCurrentCaps()
+		if caps == nil || caps.GetSize() == 0 {
+			return
+		}
+		w.mu.Lock()
+		store(caps.GetStructureAt(0).Values())
+		err := w.write()
+		w.mu.Unlock()
+		if err != nil {
+			fmt.Printf("[sdp] failed to update %s: %v\n", w.path, err)
+			return
+		}
+		fmt.Printf("[sdp] updated %s\n", w.path)
+	})
+}
+
+// sdpPath returns the SDP file of the i-th destination: the path itself for
+// the first one, then out-2.sdp, out-3.sdp, ...
+func sdpPath(path string, i int) string {
+	if i == 0 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), i+1, ext)
+}
+
+// sdpRenditionPath returns the SDP file of the i-th rendition: out-rendition1.sdp, ...
+func sdpRenditionPath(path string, i int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-rendition%d%s", strings.TrimSuffix(path, ext), i+1, ext)
+}
+
+// sdpFileMode keeps SDP files carrying the SRTP key readable by the owner only
+func sdpFileMode(config StreamConfig) os.FileMode {
+	if config.SRTPKey != nil {
+		return 0600
+	}
+	return 0644
+}
+
+// writeFileAtomic replaces a file in one step so readers never see a partial file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+// BuildRenditionSDP builds the SDP of the i-th simulcast rendition as received
+// at its destination: its video alone, without FEC and RTX, which only apply to
+// the main video
+func BuildRenditionSDP(config StreamConfig, index int, videoCaps map[string]any, sessionID, version int64) string {
+	r := config.Renditions[index]
+	rendition := renditionConfig(config, r)
+	rendition.SSRC = renditionSSRC(config, index)
+	rendition.NoAudio = true
+	rendition.FEC = FECNone
+	rendition.RTX = false
+	rendition.TelemetryRTP = false
+	rendition.SinglePipeline = false
+	rendition.Renditions = nil
+	return BuildSDP(rendition, r.Destination, videoCaps, sessionID, version)
+}
+
+// BuildSDP builds the SDP of the video and audio streams as received at dest
+func BuildSDP(config StreamConfig, dest Destination, videoCaps map[string]any, sessionID, version int64) string {
+	addrType, addr := sdpAddress(dest.Host)
+	profile := sdpProfile(config)
+	family := GetCodecFamily(config.Encoder)
+	pt := config.PayloadType
+
+	var lines []string
+	add := func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	// Session
+	add("v=0")
+	add("o=- %d %d IN %s %s", sessionID, version, addrType, sdpLocalAddress(addrType))
+	if config.NoVideo {
+		add("s=FPV audio")
+	} else {
+		add("s=FPV %s %s", family, config.Resolution.Name)
+	}
+	add("c=IN %s %s", addrType, addr)
+	add("t=0 0")
+	if group := sdpSyncGroup(config); group != "" {
+		// These streams share one clock, receivers may sync them (RFC 5888)
+		add("a=group:LS %s", group)
+	}
+
+	// MPEG-TS over RTP carries both streams on the video port
+	if config.Container == ContainerMPEGTS {
+		add("m=video %d RTP/AVP %d", dest.Port, mp2tPayloadType)
+		add("a=rtpmap:%d MP2T/%d", mp2tPayloadType, rtpClockRate)
+		return strings.Join(lines, "\r\n") + "\r\n"
+	}
+
+	if !config.NoVideo {
+		// Video
+		formats := []string{fmt.Sprint(pt)}
+		if config.FEC == FECULP {
+			formats = append(formats, fmt.Sprint(fecPayloadType))
+		}
+		if config.RTX {
+			formats = append(formats, fmt.Sprint(videoRTXPayloadType))
+		}
+		add("m=video %d %s %s", dest.Port, profile, strings.Join(formats, " "))
+		add("a=rtpmap:%d %s/%d", pt, family, rtpClockRate)
+		if fmtp := sdpVideoFmtp(family, videoCaps); fmtp != "" {
+			add("a=fmtp:%d %s", pt, fmtp)
+		}
+		if config.FEC == FECULP {
+			add("a=rtpmap:%d ulpfec/%d", fecPayloadType, rtpClockRate)
+		}
+		if config.RTX {
+			add("a=rtpmap:%d rtx/%d", videoRTXPayloadType, rtpClockRate)
+			add("a=fmtp:%d apt=%d;rtx-time=%d", videoRTXPayloadType, pt, config.RTXHistory.Milliseconds())
+			add("a=rtcp-fb:%d nack", pt)
+		}
+		add("a=rtcp:%d", dest.RTCPPort)
+		if config.SinglePipeline || config.TelemetryRTP {
+			add("a=mid:video")
+		}
+		add("a=framerate:%d", config.Framerate)
+		lines = append(lines, sdpStreamAttributes(config, config.SSRC)...)
+	}
+
+	if !config.NoAudio {
+		// Audio, retransmitted too when it shares the video rtpbin
+		audioPT := audioPayloadType(config.Audio)
+		audioRTX := config.RTX && config.SinglePipeline
+		if audioRTX {
+			add("m=audio %d %s %d %d", dest.AudioPort, profile, audioPT, audioRTXPayloadType)
+		} else {
+			add("m=audio %d %s %d", dest.AudioPort, profile, audioPT)
+		}
+		encoding, fmtp := sdpAudioFormat(config.Audio)
+		add("a=rtpmap:%d %s", audioPT, encoding)
+		if fmtp != "" {
+			add("a=fmtp:%d %s", audioPT, fmtp)
+		}
+		if audioRTX {
+			add("a=rtpmap:%d rtx/%d", audioRTXPayloadType, audioClockRate(config.Audio))
+			add("a=fmtp:%d apt=%d;rtx-time=%d", audioRTXPayloadType, audioPT, config.RTXHistory.Milliseconds())
+			add("a=rtcp-fb:%d nack", audioPT)
+		}
+		add("a=rtcp:%d", dest.AudioRTCPPort)
+		if config.SinglePipeline {
+			add("a=mid:audio")
+		}
+		lines = append(lines, sdpStreamAttributes(config, audioSSRC(config))...)
+	}
+
+	if config.TelemetryRTP {
+		// Telemetry as KLV units (RFC 6597)
+		add("m=application %d %s %d", dest.TelemetryPort, profile, defaultTelemetryPayloadType)
+		add("a=rtpmap:%d smpte336m/%d", defaultTelemetryPayloadType, rtpClockRate)
+		add("a=rtcp:%d", telemetryRTCPPort(dest))
+		add("a=mid:telemetry")
+		lines = append(lines, sdpStreamAttributes(config, telemetrySSRC(config))...)
+	}
+
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// sdpSyncGroup returns the mids of the streams sharing one clock, or "" when
+// there is nothing to group. Telemetry always runs on the video clock.
+func sdpSyncGroup(config StreamConfig) string {
+	if config.Container == ContainerMPEGTS {
+		return ""
+	}
+	var mids []string
+	if config.SinglePipeline && !config.NoVideo && !config.NoAudio {
+		mids = append(mids, "video", "audio")
+	}
+	if config.TelemetryRTP {
+		if len(mids) == 0 {
+			mids = append(mids, "video")
+		}
+		mids = append(mids, "telemetry")
+	}
+	return strings.Join(mids, " ")
+}
+
+// sdpStreamAttributes returns the per-media SSRC and crypto attributes
+func sdpStreamAttributes(config StreamConfig, ssrc uint32) []string {
+	var lines []string
+	if ssrc != 0 && config.CNAME != "" {
+		lines = append(lines, fmt.Sprintf("a=ssrc:%d cname:%s", ssrc, config.CNAME))
+	}
+	if config.SRTPKey != nil {
+		key, mki := currentSRTPKey(config)
+		if line := buildSDPCrypto(config.SRTPCipher, config.SRTPAuth, key, mki); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// sdpVideoFmtp builds the a=fmtp parameters of the video stream from the
+// payloader caps. H.264 defaults to non-interleaved mode until caps are known.
+func sdpVideoFmtp(family CodecFamily, videoCaps map[string]any) string {
+	if videoCaps == nil {
+		if family == CodecH264 {
+			return "packetization-mode=1"
+		}
+		return ""
+	}
+
+	var params []string
+	for _, key := range sdpVideoFmtpKeys[family] {
+		if value, ok := videoCaps[key]; ok {
+			params = append(params, fmt.Sprintf("%s=%v", key, value))
+		}
+	}
+	return strings.Join(params, ";")
+}
+
+// sdpProfile returns the RTP profile of the m= lines
+func sdpProfile(config StreamConfig) string {
+	switch {
+	case config.SRTPKey != nil && config.RTX:
+		return "RTP/SAVPF"
+	case config.SRTPKey != nil:
+		return "RTP/SAVP"
+	case config.RTX:
+		return "RTP/AVPF"
+	}
+	return "RTP/AVP"
+}
+
+// sdpAddress returns the SDP address type and address of a host. SDP has no
+// syntax for IPv6 zone IDs, so they are dropped.
+func sdpAddress(host string) (string, string) {
+	if strings.Contains(host, ":") {
+		if i := strings.Index(host, "%"); i >= 0 {
+			host = host[:i]
+		}
+		return "IP6", host
+	}
+	return "IP4", host
+}
+
+// sdpLocalAddress returns the origin address for an address type
+func sdpLocalAddress(addrType string) string {
+	if addrType == "IP6" {
+		return "::1"
+	}
+	return "127.0.0.1"
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openSerial opens a serial port (or pty) in raw 8N1 mode at the given baud rate
+func openSerial(path string, baud int) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Raw mode: no line discipline, blocking reads of at least one byte.
+	// Darwin takes the baud rate as a plain number.
+	termios := syscall.Termios{
+		Cflag:  syscall.CS8 | syscall.CREAD | syscall.CLOCAL,
+		Ispeed: uint64(baud),
+		Ospeed: uint64(baud),
+	}
+	termios.Cc[syscall.VMIN] = 1
+	termios.Cc[syscall.VTIME] = 0
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), syscall.TIOCSETA, uintptr(unsafe.Pointer(&termios))); errno != 0 {
+		file.Close()
+		return nil, fmt.Errorf("failed to configure %s: %w", path, errno)
+	}
+	return file, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Linux termios values (asm-generic, as on x86 and ARM). The syscall package
+// does not export them for every architecture.
+const (
+	termiosCS8    = 0x30
+	termiosCREAD  = 0x80
+	termiosCLOCAL = 0x800
+	termiosVMIN   = 6
+	termiosVTIME  = 5
+)
+
+// serialBauds maps baud rates to their termios speed codes
+var serialBauds = map[int]uint32{
+	9600:    0xd,
+	19200:   0xe,
+	38400:   0xf,
+	57600:   0x1001,
+	115200:  0x1002,
+	230400:  0x1003,
+	460800:  0x1004,
+	500000:  0x1005,
+	921600:  0x1007,
+	1000000: 0x1008,
+	1500000: 0x100a,
+	2000000: 0x100b,
+}
+
+// openSerial opens a serial port (or pty) in raw 8N1 mode at the given baud rate
+func openSerial(path string, baud int) (*os.File, error) {
+	speed, ok := serialBauds[baud]
+	if !ok {
+		return nil, fmt.Errorf("unsupported baud rate: %d", baud)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Raw mode: no line discipline, blocking reads of at least one byte
+	termios := syscall.Termios{Cflag: termiosCS8 | termiosCREAD | termiosCLOCAL | speed}
+	termios.Cc[termiosVMIN] = 1
+	termios.Cc[termiosVTIME] = 0
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&termios))); errno != 0 {
+		file.Close()
+		return nil, fmt.Errorf("failed to configure %s: %w", path, errno)
+	}
+	return file, nil
+}
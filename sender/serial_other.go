@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// openSerial is only implemented for Linux and macOS; use a UDP telemetry link elsewhere
+func openSerial(path string, baud int) (*os.File, error) {
+	return nil, fmt.Errorf("serial telemetry is not supported on this platform, use udp:PORT")
+}
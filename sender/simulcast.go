@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-gst/go-gst/gst"
+)
+
+// firstRenditionSessionID is the rtpbin session of the first simulcast
+// rendition, after the video, audio and telemetry sessions
+const firstRenditionSessionID = 3
+
+// maxRenditions bounds the simulcast renditions next to the main video
+const maxRenditions = 4
+
+// videoTeeName names the tee splitting the capture between the main video and
+// the simulcast renditions
+const videoTeeName = "video-tee"
+
+// Rendition is an additional video output encoded from the same capture as
+// the main video, with its own encoder, resolution, bitrate and destination
+type Rendition struct {
+	Encoder     EncoderType
+	Resolution  Resolution
+	Framerate   int
+	Bitrate     int // kbps, 0 for the encoder default
+	Destination Destination
+}
+
+func (r Rendition) String() string {
+	desc := fmt.Sprintf("%s %s@%d", r.Encoder, r.Resolution.Name, r.Framerate)
+	if r.Bitrate > 0 {
+		desc += fmt.Sprintf(", %d kbps", r.Bitrate)
+	}
+	return desc
+}
+
+// ParseRendition parses a rendition such as
+// encoder=vp8enc,resolution=VGA,fps=30,bitrate=800,dest=10.0.0.5:5000. The
+// framerate defaults to the main video's.
+func ParseRendition(spec string, framerate int) (Rendition, error) {
+	r := Rendition{Framerate: framerate}
+	var dest string
+	for _, field := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return Rendition{}, fmt.Errorf("invalid rendition field %q, expected key=value", field)
+		}
+		var err error
+		switch strings.ToLower(key) {
+		case "encoder":
+			r.Encoder, _, err = ValidateEncoder(value)
+		case "resolution":
+			r.Resolution, err = ValidateResolution(value)
+		case "fps":
+			r.Framerate, err = strconv.Atoi(value)
+			if err != nil || r.Framerate < 1 || r.Framerate > 240 {
+				err = fmt.Errorf("invalid rendition framerate %q, expected 1 to 240", value)
+			}
+		case "bitrate":
+			r.Bitrate, err = strconv.Atoi(value)
+			if err != nil {
+				err = fmt.Errorf("invalid rendition bitrate %q", value)
+			} else {
+				err = ValidateVideoBitrate(r.Bitrate)
+			}
+		case "dest":
+			dest = value
+		default:
+			err = fmt.Errorf("unknown rendition field %q, expected encoder, resolution, fps, bitrate or dest", key)
+		}
+		if err != nil {
+			return Rendition{}, err
+		}
+	}
+
+	switch {
+	case r.Encoder == "":
+		return Rendition{}, fmt.Errorf("rendition %q needs an encoder", spec)
+	case r.Resolution.Name == "":
+		return Rendition{}, fmt.Errorf("rendition %q needs a resolution", spec)
+	case dest == "":
+		return Rendition{}, fmt.Errorf("rendition %q needs a dest", spec)
+	}
+	// Each rendition has its own RTP and RTCP ports on its destination
+	d, err := parseDestination(dest, PortLayout{})
+	if err != nil {
+		return Rendition{}, fmt.Errorf("invalid rendition destination: %w", err)
+	}
+	r.Destination = d
+	return r, nil
+}
+
+// checkRenditionPorts fails when a rendition's RTP or RTCP port is already
+// used on the same host by a main destination or another rendition
+func checkRenditionPorts(r Rendition, dests []Destination, others []Rendition) error {
+	used := map[int]bool{}
+	for _, d := range dests {
+		if d.Host != r.Destination.Host {
+			continue
+		}
+		for _, port := range []int{d.Port, d.AudioPort, d.RTCPPort, d.AudioRTCPPort, d.FECPort, d.FECPort + 1, d.TelemetryPort, d.TelemetryPort + 1} {
+			used[port] = true
+		}
+	}
+	for _, other := range others {
+		if other.Destination.Host == r.Destination.Host {
+			used[other.Destination.Port] = true
+			used[other.Destination.RTCPPort] = true
+		}
+	}
+	if used[r.Destination.Port] || used[r.Destination.RTCPPort] {
+		return fmt.Errorf("rendition destination %s overlaps the ports of another stream to that host", r.Destination)
+	}
+	return nil
+}
+
+// renditionSessionID returns the rtpbin session of a rendition
+func renditionSessionID(index int) uint {
+	return uint(firstRenditionSessionID + index)
+}
+
+// renditionSessionIDs returns the rtpbin sessions of all renditions
+func renditionSessionIDs(config StreamConfig) []uint {
+	ids := make([]uint, len(config.Renditions))
+	for i := range config.Renditions {
+		ids[i] = renditionSessionID(i)
+	}
+	return ids
+}
+
+// renditionPayloaderName names the payloader of a rendition
+func renditionPayloaderName(index int) string {
+	return sessionLabel(renditionSessionID(index)) + "-pay"
+}
+
+// renditionSSRC returns the SSRC of a rendition, derived from the video SSRC
+// after the audio and telemetry ones (0 = random)
+func renditionSSRC(config StreamConfig, index int) uint32 {
+	if config.SSRC == 0 {
+		return 0
+	}
+	return config.SSRC + uint32(firstRenditionSessionID+index)
+}
+
+// renditionSession returns the RTP session of a rendition. Renditions send
+// RTCP sender reports but receive no feedback, that stays with the main video.
+func renditionSession(config StreamConfig, index int) rtpSession {
+	id := renditionSessionID(index)
+	return rtpSession{
+		ID:       int(id),
+		Label:    sessionLabel(id),
+		RTPPort:  videoPort,
+		RTCPPort: videoRTCPPort,
+		Loss:     config.SimulateLoss,
+		SRTP:     config.SRTPKey != nil,
+	}
+}
+
+// renditionConfig returns the config of the main video with the encoder,
+// resolution and framerate of a rendition
+func renditionConfig(config StreamConfig, r Rendition) StreamConfig {
+	config.Encoder = r.Encoder
+	config.Resolution = r.Resolution
+	config.Framerate = r.Framerate
+	return config
+}
+
+// addRenditionBranch adds the scale, encode and payload chain of a rendition,
+// fed by the video tee, and sends it through its own rtpbin session
+func addRenditionBranch(pipeline *gst.Pipeline, rtpbin *gst.Element, config StreamConfig, index int) error {
+	r := config.Renditions[index]
+	label := sessionLabel(renditionSessionID(index))
+	tee, err := pipeline.GetElementByName(videoTeeName)
+	if err != nil {
+		return fmt.Errorf("failed to find video tee: %w", err)
+	}
+
+	// A leaky queue so a slow encoder does not stall the other branches
+	queue, _ := gst.NewElement("queue")
+	queue.SetProperty("max-size-buffers", 1)
+	queue.SetProperty("leaky", 2) // downstream
+	scale, _ := gst.NewElement("videoscale")
+	rate, _ := gst.NewElement("videorate")
+	convert, _ := gst.NewElement("videoconvert")
+	elements := []*gst.Element{queue, scale, rate, convert}
+
+	platform, _ := detectPlatform()
+	capsFilter, _ := gst.NewElement("capsfilter")
+	capsFilter.SetProperty("caps", gst.NewCapsFromString(buildVideoCaps(renditionConfig(config, r), platform)))
+	elements = append(elements, capsFilter)
+
+	if NeedsNVMMMemory(r.Encoder) {
+		nvConv, _ := gst.NewElement("nvvideoconvert")
+		nvCapsFilter, _ := gst.NewElement("capsfilter")
+		nvCapsFilter.SetProperty("caps", gst.NewCapsFromString("video/x-raw(memory:NVMM),format=NV12"))
+		elements = append(elements, nvConv, nvCapsFilter)
+	}
+
+	encoder, err := createVideoEncoder(r.Encoder, label+"-encoder")
+	if err != nil {
+		return err
+	}
+	if r.Bitrate > 0 {
+		if err := setEncoderBitrate(encoder, r.Encoder, r.Bitrate); err != nil {
+			return err
+		}
+	}
+	elements = append(elements, encoder)
+
+	parser, payloader, err := createVideoParserPayloader(r.Encoder, renditionPayloaderName(index))
+	if err != nil {
+		return err
+	}
+	configurePayloader(payloader, renditionSSRC(config, index), config.PayloadType)
+	if parser != nil {
+		elements = append(elements, parser)
+	}
+	elements = append(elements, payloader)
+
+	if err := addAndLinkElements(pipeline, elements); err != nil {
+		return err
+	}
+	if err := tee.Link(queue); err != nil {
+		return fmt.Errorf("failed to link %s to %s: %w", tee.GetName(), label, err)
+	}
+	return linkRTPSession(pipeline, rtpbin, payloader, renditionSession(config, index), []Destination{r.Destination})
+}
+
+// buildRenditionCommands returns the gst-launch fragments of the renditions,
+// each branching off the video tee into its own rtpbin session
+func buildRenditionCommands(rtpbinName string, config StreamConfig) []string {
+	platform, _ := detectPlatform()
+	var fragments []string
+	for i, r := range config.Renditions {
+		parts := []string{
+			videoTeeName + ".",
+			"queue max-size-buffers=1 leaky=downstream",
+			"videoscale",
+			"videorate",
+			"videoconvert",
+			buildVideoCaps(renditionConfig(config, r), platform),
+		}
+		if NeedsNVMMMemory(r.Encoder) {
+			parts = append(parts, "nvvideoconvert", `"video/x-raw(memory:NVMM),format=NV12"`)
+		}
+		encode := buildVideoEncoderCommand(r.Encoder, renditionSSRC(config, i), config.PayloadType)
+		if bitrate, ok := encoderBitrates[r.Encoder]; ok && r.Bitrate > 0 {
+			encode[0] += fmt.Sprintf(" %s=%d", bitrate.Property, r.Bitrate*1000/bitrate.Scale)
+		}
+		parts = append(parts, encode...)
+		parts = append(parts, fmt.Sprintf("%s.send_rtp_sink_%d", rtpbinName, renditionSessionID(i)))
+
+		fragments = append(fragments, strings.Join(parts, " ! \\\n    "))
+		fragments = append(fragments, buildRTPSessionCommand(rtpbinName, renditionSession(config, i), []Destination{r.Destination})...)
+	}
+	return fragments
+}
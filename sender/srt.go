@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-gst/go-gst/gst"
+)
+
+// srtScheme prefixes destinations sent over SRT instead of RTP/UDP
+const srtScheme = "srt://"
+
+// srtStatsKeys are the srtsink stats fields reported in the stats output. In
+// listener mode libsrt reports per caller, so only the totals are available.
+var srtStatsKeys = []string{
+	"rtt-ms", "bandwidth-mbps", "send-rate-mbps",
+	"packets-sent", "packets-sent-lost", "packets-retransmitted",
+	"bytes-sent-total",
+}
+
+// isSRTDestination reports whether a destination argument is an SRT URI
+func isSRTDestination(addr string) bool {
+	return strings.HasPrefix(addr, srtScheme)
+}
+
+// parseSRTURI validates an SRT URI such as
+// srt://host:port?mode=caller&latency=120&passphrase=... and returns it
+// unchanged for srtsink, which reads the options from the query itself
+func parseSRTURI(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid SRT URI: %w", err)
+	}
+	if u.Port() == "" {
+		return "", fmt.Errorf("SRT URI needs a port, e.g. srt://192.168.1.10:7001")
+	}
+	if port, err := strconv.Atoi(u.Port()); err != nil || port < 1 || port > 65535 {
+		return "", fmt.Errorf("invalid SRT port: %s", u.Port())
+	}
+
+	query := u.Query()
+	switch mode := query.Get("mode"); mode {
+	case "", "caller", "rendezvous":
+		if u.Hostname() == "" {
+			return "", fmt.Errorf("SRT %s mode needs a host", defaultString(mode, "caller"))
+		}
+	case "listener":
+	default:
+		return "", fmt.Errorf("unsupported SRT mode: %s (supported: caller, listener, rendezvous)", mode)
+	}
+	if latency := query.Get("latency"); latency != "" {
+		if ms, err := strconv.Atoi(latency); err != nil || ms < 0 {
+			return "", fmt.Errorf("SRT latency must be milliseconds, got: %s", latency)
+		}
+	}
+	if passphrase := query.Get("passphrase"); passphrase != "" && (len(passphrase) < 10 || len(passphrase) > 79) {
+		return "", fmt.Errorf("SRT passphrase must be 10 to 79 characters")
+	}
+	switch pbkeylen := query.Get("pbkeylen"); pbkeylen {
+	case "", "0", "16", "24", "32":
+	default:
+		return "", fmt.Errorf("SRT pbkeylen must be 16, 24 or 32, got: %s", pbkeylen)
+	}
+	return raw, nil
+}
+
+// redactSRTURI hides the passphrase of an SRT URI for display
+func redactSRTURI(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	query := u.Query()
+	if query.Get("passphrase") == "" {
+		return raw
+	}
+	query.Set("passphrase", "***")
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// defaultString returns s, or def when s is empty
+func defaultString(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// createSRTSink creates the srtsink for the configured URI. It does not wait
+// for a caller in listener mode, so the encoder keeps running until one connects.
+func createSRTSink(config StreamConfig) (*gst.Element, error) {
+	sink, err := gst.NewElementWithName("srtsink", "srt-sink")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create srtsink: %w", err)
+	}
+	sink.SetProperty("uri", config.SRTURI)
+	sink.SetProperty("wait-for-connection", false)
+	return sink, nil
+}
+
+// buildSRTSinkCommand returns the gst-launch fragment of the srtsink. It is
+// printed, so the passphrase is redacted; only createSRTSink sees it.
+func buildSRTSinkCommand(config StreamConfig) string {
+	return fmt.Sprintf("srtsink name=srt-sink uri=\"%s\" wait-for-connection=false", redactSRTURI(config.SRTURI))
+}
+
+// srtStats returns a stats source reporting RTT, retransmissions and bandwidth
+// from the srtsink stats property
+func srtStats(pipeline *gst.Pipeline) func() map[string]any {
+	return func() map[string]any {
+		sink, err := pipeline.GetElementByName("srt-sink")
+		if err != nil || sink == nil {
+			return nil
+		}
+		value, err := sink.GetProperty("stats")
+		if err != nil {
+			return nil
+		}
+		stats, ok := value.(*gst.Structure)
+		if !ok || stats == nil {
+			return nil
+		}
+
+		values := make(map[string]any)
+		for _, key := range srtStatsKeys {
+			if v, err := stats.GetValue(key); err == nil && v != nil {
+				values[key] = v
+			}
+		}
+		if len(values) == 0 {
+			return nil
+		}
+		return values
+	}
+}
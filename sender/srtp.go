@@ -0,0 +1,311 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-gst/go-glib/glib"
+	"github.com/go-gst/go-gst/gst"
+)
+
+// SRTP cipher suites (srtpenc rtp-cipher/rtcp-cipher nicks)
+const (
+	SRTPCipherAES128ICM = "aes-128-icm"
+	SRTPCipherAES256ICM = "aes-256-icm"
+	SRTPCipherAES128GCM = "aes-128-gcm"
+	SRTPCipherAES256GCM = "aes-256-gcm"
+	SRTPCipherNull      = "null"
+)
+
+// SRTP authentication suites (srtpenc rtp-auth/rtcp-auth nicks)
+const (
+	SRTPAuthHMACSHA1_80 = "hmac-sha1-80"
+	SRTPAuthHMACSHA1_32 = "hmac-sha1-32"
+	SRTPAuthNull        = "null"
+)
+
+// srtpRandomKey is the --srtp-key value that asks for a generated key
+const srtpRandomKey = "random"
+
+// srtpMKILength is the MKI size in bytes used when keys are rotated
+const srtpMKILength = 4
+
+// srtpKeyLengths is the master key + salt length in bytes per cipher
+var srtpKeyLengths = map[string]int{
+	SRTPCipherAES128ICM: 30,
+	SRTPCipherAES256ICM: 46,
+	SRTPCipherAES128GCM: 28,
+	SRTPCipherAES256GCM: 44,
+	SRTPCipherNull:      30,
+}
+
+// ValidateSRTP checks a cipher/auth suite combination
+func ValidateSRTP(cipher, auth string) error {
+	if _, ok := srtpKeyLengths[cipher]; !ok {
+		return fmt.Errorf("unsupported SRTP cipher: %s (supported: %s, %s, %s, %s, %s)", cipher,
+			SRTPCipherAES128ICM, SRTPCipherAES256ICM, SRTPCipherAES128GCM, SRTPCipherAES256GCM, SRTPCipherNull)
+	}
+	switch auth {
+	case SRTPAuthHMACSHA1_80, SRTPAuthHMACSHA1_32, SRTPAuthNull:
+	default:
+		return fmt.Errorf("unsupported SRTP auth: %s (supported: %s, %s, %s)", auth,
+			SRTPAuthHMACSHA1_80, SRTPAuthHMACSHA1_32, SRTPAuthNull)
+	}
+	isGCM := cipher == SRTPCipherAES128GCM || cipher == SRTPCipherAES256GCM
+	if isGCM && auth != SRTPAuthNull {
+		return fmt.Errorf("%s authenticates by itself, use --srtp-auth %s", cipher, SRTPAuthNull)
+	}
+	if cipher == SRTPCipherNull && auth == SRTPAuthNull {
+		return fmt.Errorf("SRTP with null cipher and null auth provides no protection")
+	}
+	return nil
+}
+
+// loadSRTPKey returns the master key + salt for a cipher from a base64 key,
+// the contents of a key file, or a freshly generated random key, and whether
+// it was generated
+func loadSRTPKey(key, keyFile, cipher string) ([]byte, bool, error) {
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read SRTP key file: %w", err)
+		}
+		key = strings.TrimSpace(string(data))
+	}
+	if key == srtpRandomKey {
+		generated, err := generateSRTPKey(cipher)
+		return generated, true, err
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, false, fmt.Errorf("SRTP key must be base64: %w", err)
+	}
+	if want := srtpKeyLengths[cipher]; len(decoded) != want {
+		return nil, false, fmt.Errorf("SRTP key for %s must be %d bytes, got %d", cipher, want, len(decoded))
+	}
+	return decoded, false, nil
+}
+
+// writeSRTPKey writes a key in base64 to the --srtp-key-out file, readable
+// by the owner only and in the format --srtp-key-file reads
+func writeSRTPKey(path string, key []byte) error {
+	return writeFileAtomic(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600)
+}
+
+// generateSRTPKey returns a random master key + salt for a cipher
+func generateSRTPKey(cipher string) ([]byte, error) {
+	key := make([]byte, srtpKeyLengths[cipher])
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate SRTP key: %w", err)
+	}
+	return key, nil
+}
+
+// srtpKeyring holds the current SRTP master key and its MKI, replaced on every
+// rotation. Encoders, decoders and SDP files take the key from here. Safe for
+// concurrent use.
+type srtpKeyring struct {
+	mu  sync.Mutex
+	key []byte
+	mki uint32 // 0 when keys are not rotated
+}
+
+// newSRTPKeyring starts a keyring at key, with MKI 1 when keys are rotated
+func newSRTPKeyring(key []byte, rotate bool) *srtpKeyring {
+	k := &srtpKeyring{key: key}
+	if rotate {
+		k.mki = 1
+	}
+	return k
+}
+
+// Current returns the current key and its MKI
+func (k *srtpKeyring) Current() ([]byte, uint32) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.key, k.mki
+}
+
+// rotate makes key the current one under the next MKI and returns that MKI
+func (k *srtpKeyring) rotate(key []byte) uint32 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.key = key
+	k.mki++
+	return k.mki
+}
+
+// currentSRTPKey returns the key and MKI in use, the startup key when the
+// config has no keyring
+func currentSRTPKey(config StreamConfig) ([]byte, uint32) {
+	if config.SRTPKeys != nil {
+		return config.SRTPKeys.Current()
+	}
+	if config.SRTPRotate > 0 {
+		return config.SRTPKey, 1
+	}
+	return config.SRTPKey, 0
+}
+
+// srtpMKI encodes a key index as the MKI carried in each packet
+func srtpMKI(index uint32) []byte {
+	mki := make([]byte, srtpMKILength)
+	binary.BigEndian.PutUint32(mki, index)
+	return mki
+}
+
+// enableSRTP makes rtpbin encrypt the given send sessions with srtpenc and
+// decrypt incoming RTCP with srtpdec. rtpbin places the encoder at the end of
+// the send path, so payloaders, FEC and RTX see plain RTP and SRTCP shares the
+// key. Must be called before any send pads are requested.
+func enableSRTP(rtpbin *gst.Element, config StreamConfig, sessionIDs ...uint) error {
+	encoders := make(map[uint]*gst.Element, len(sessionIDs))
+	decoders := make(map[uint]*gst.Element, len(sessionIDs))
+	key, mki := currentSRTPKey(config)
+	for _, id := range sessionIDs {
+		enc, err := gst.NewElementWithName("srtpenc", srtpEncoderName(id))
+		if err != nil {
+			return fmt.Errorf("failed to create srtpenc: %w", err)
+		}
+		enc.SetArg("rtp-cipher", config.SRTPCipher)
+		enc.SetArg("rtp-auth", config.SRTPAuth)
+		enc.SetArg("rtcp-cipher", config.SRTPCipher)
+		enc.SetArg("rtcp-auth", config.SRTPAuth)
+		if mki > 0 {
+			enc.SetArg("mki", hex.EncodeToString(srtpMKI(mki)))
+		}
+		enc.SetArg("key", hex.EncodeToString(key))
+		encoders[id] = enc
+
+		dec, err := gst.NewElementWithName("srtpdec", srtpDecoderName(id))
+		if err != nil {
+			return fmt.Errorf("failed to create srtpdec: %w", err)
+		}
+		// Receivers encrypt their RTCP feedback with the current key. srtpdec
+		// asks again after a rotation cleared its keys.
+		dec.Connect("request-key", func(self *gst.Element, ssrc uint) *gst.Caps {
+			return gst.NewCapsFromString(buildSRTPCaps("application/x-srtcp", config))
+		})
+		decoders[id] = dec
+	}
+
+	rtpbin.Connect("request-rtp-encoder", func(self *gst.Element, sessionID uint) *gst.Element {
+		return encoders[sessionID]
+	})
+	rtpbin.Connect("request-rtcp-encoder", func(self *gst.Element, sessionID uint) *gst.Element {
+		return encoders[sessionID]
+	})
+	rtpbin.Connect("request-rtcp-decoder", func(self *gst.Element, sessionID uint) *gst.Element {
+		return decoders[sessionID]
+	})
+	return nil
+}
+
+// srtpEncoderName returns the name of the srtpenc of an RTP session
+func srtpEncoderName(sessionID uint) string {
+	return sessionLabel(sessionID) + "-srtpenc"
+}
+
+// srtpDecoderName returns the name of the srtpdec of an RTP session
+func srtpDecoderName(sessionID uint) string {
+	return sessionLabel(sessionID) + "-srtpdec"
+}
+
+// buildSRTPCaps returns caps carrying the current key for srtpdec
+func buildSRTPCaps(mediaType string, config StreamConfig) string {
+	key, mki := currentSRTPKey(config)
+	caps := fmt.Sprintf("%s, srtp-key=(buffer)%s, srtp-cipher=(string)%s, srtp-auth=(string)%s, srtcp-cipher=(string)%s, srtcp-auth=(string)%s",
+		mediaType, hex.EncodeToString(key),
+		config.SRTPCipher, config.SRTPAuth, config.SRTPCipher, config.SRTPAuth)
+	if mki > 0 {
+		caps += fmt.Sprintf(", mki=(buffer)%s", hex.EncodeToString(srtpMKI(mki)))
+	}
+	return caps
+}
+
+// startSRTPRotation replaces the SRTP key of all encoders every interval. Each
+// new key gets the next MKI so receivers holding several keys can tell them
+// apart, and is handed to them through the --srtp-key-out file and the SDP
+// files (sdp may be nil), never the log. The decoders drop the old key and
+// request the new one for the receivers' RTCP.
+func startSRTPRotation(config StreamConfig, sdp *SDPWriter, pipelines ...*gst.Pipeline) {
+	glib.TimeoutAdd(uint(config.SRTPRotate.Milliseconds()), func() bool {
+		key, err := generateSRTPKey(config.SRTPCipher)
+		if err != nil {
+			fmt.Printf("[srtp] key rotation failed: %v\n", err)
+			return true
+		}
+		if err := writeSRTPKey(config.SRTPKeyOut, key); err != nil {
+			fmt.Printf("[srtp] key rotation failed: %v\n", err)
+			return true
+		}
+		index := config.SRTPKeys.rotate(key)
+		for _, pipeline := range pipelines {
+			for _, id := range append([]uint{videoSessionID, audioSessionID, telemetrySessionID}, renditionSessionIDs(config)...) {
+				if enc, err := pipeline.GetElementByNameRecursive(srtpEncoderName(id)); err == nil && enc != nil {
+					enc.SetArg("mki", hex.EncodeToString(srtpMKI(index)))
+					enc.SetArg("key", hex.EncodeToString(key))
+				}
+				if dec, err := pipeline.GetElementByNameRecursive(srtpDecoderName(id)); err == nil && dec != nil {
+					dec.Emit("clear-keys")
+				}
+			}
+		}
+		if sdp != nil {
+			if err := sdp.Write(nil); err != nil {
+				fmt.Printf("[srtp] failed to update the SDP files: %v\n", err)
+			}
+		}
+		fmt.Printf("[srtp] rotated key, MKI %d, written to %s\n", index, config.SRTPKeyOut)
+		return true
+	})
+}
+
+// srtpSDPSuite returns the SDES crypto suite name of a cipher/auth combination
+// (RFC 4568, 6188, 7714), or "" when SDP has no name for it
+func srtpSDPSuite(cipher, auth string) string {
+	switch {
+	case cipher == SRTPCipherAES128ICM && auth == SRTPAuthHMACSHA1_80:
+		return "AES_CM_128_HMAC_SHA1_80"
+	case cipher == SRTPCipherAES128ICM && auth == SRTPAuthHMACSHA1_32:
+		return "AES_CM_128_HMAC_SHA1_32"
+	case cipher == SRTPCipherAES256ICM && auth == SRTPAuthHMACSHA1_80:
+		return "AES_256_CM_HMAC_SHA1_80"
+	case cipher == SRTPCipherAES256ICM && auth == SRTPAuthHMACSHA1_32:
+		return "AES_256_CM_HMAC_SHA1_32"
+	case cipher == SRTPCipherAES128GCM:
+		return "AEAD_AES_128_GCM"
+	case cipher == SRTPCipherAES256GCM:
+		return "AEAD_AES_256_GCM"
+	}
+	return ""
+}
+
+// buildSDPCrypto returns the SDP a=crypto line for a key, with the MKI when
+// keys are rotated (mki 0 = no MKI)
+func buildSDPCrypto(cipher, auth string, key []byte, mki uint32) string {
+	suite := srtpSDPSuite(cipher, auth)
+	if suite == "" {
+		return ""
+	}
+	params := "inline:" + base64.StdEncoding.EncodeToString(key)
+	if mki > 0 {
+		params += fmt.Sprintf("|%d:%d", mki, srtpMKILength)
+	}
+	return fmt.Sprintf("a=crypto:1 %s %s", suite, params)
+}
+
+// describeSRTP describes the SRTP setup, which the printed gst-launch command
+// cannot express since it is attached through rtpbin's request-rtp-encoder
+// signal. The key is left out.
+func describeSRTP(config StreamConfig) string {
+	return fmt.Sprintf("srtpenc rtp-cipher=%s rtp-auth=%s rtcp-cipher=%s rtcp-auth=%s key=<hex key> (attached via rtpbin request-rtp-encoder)",
+		config.SRTPCipher, config.SRTPAuth, config.SRTPCipher, config.SRTPAuth)
+}
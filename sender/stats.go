@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gst/go-glib/glib"
+)
+
+// StatsReporter collects statistics from the running pipelines and prints them
+// periodically. Each source contributes one named section of values.
+type StatsReporter struct {
+	mu      sync.Mutex
+	sources []statsSource
+}
+
+type statsSource struct {
+	name    string
+	collect func() map[string]any
+}
+
+// NewStatsReporter creates an empty stats reporter
+func NewStatsReporter() *StatsReporter {
+	return &StatsReporter{}
+}
+
+// Add registers a stats source. collect returns nil when it has nothing to report.
+func (r *StatsReporter) Add(name string, collect func() map[string]any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sources = append(r.sources, statsSource{name: name, collect: collect})
+}
+
+// Collect returns a snapshot of all stats sections
+func (r *StatsReporter) Collect() map[string]map[string]any {
+	r.mu.Lock()
+	sources := append([]statsSource(nil), r.sources...)
+	r.mu.Unlock()
+
+	stats := make(map[string]map[string]any, len(sources))
+	for _, source := range sources {
+		if values := source.collect(); values != nil {
+			stats[source.name] = values
+		}
+	}
+	return stats
+}
+
+// Start prints the collected stats every interval on the GLib main loop
+func (r *StatsReporter) Start(interval time.Duration) {
+	glib.TimeoutAdd(uint(interval.Milliseconds()), func() bool {
+		stats := r.Collect()
+		names := make([]string, 0, len(stats))
+		for name := range stats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("[stats] %s: %s\n", name, formatStats(stats[name]))
+		}
+		return true
+	})
+}
+
+// formatStats formats a stats section as sorted key=value pairs
+func formatStats(values map[string]any) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", key, values[key]))
+	}
+	return strings.Join(pairs, " ")
+}
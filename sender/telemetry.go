@@ -0,0 +1,400 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Telemetry link defaults
+const (
+	defaultSerialBaud      = 57600
+	telemetryRetryInterval = 2 * time.Second
+	telemetryStaleAfter    = 3 * time.Second
+)
+
+// TelemetryState is a snapshot of the flight controller state. Units are SI
+// and degrees; the Has flags tell which message groups were received.
+type TelemetryState struct {
+	Updated  time.Time // Last decoded message
+	Messages int       // Decoded messages since start
+
+	HasAttitude      bool
+	Roll, Pitch, Yaw float64 // Degrees, roll right and pitch up positive
+
+	HasGPS              bool
+	GPSFix              int // 0-1 no fix, 2 = 2D, 3 = 3D, higher = DGPS/RTK
+	Satellites          int // -1 when unknown
+	Latitude, Longitude float64
+	GPSAltitude         float64 // Meters above mean sea level
+	GPSSpeed            float64 // Meters per second over ground
+
+	HasBattery       bool
+	Voltage          float64 // Volts
+	Current          float64 // Amperes, -1 when unknown
+	BatteryRemaining int     // Percent, -1 when unknown
+
+	HasAltitude bool
+	Altitude    float64 // Meters
+	Climb       float64 // Meters per second
+
+	HasHUD      bool
+	Airspeed    float64 // Meters per second
+	Groundspeed float64 // Meters per second
+	Heading     int     // Degrees
+	Throttle    int     // Percent
+
+	HasRC      bool
+	RCChannels []uint16 // Microseconds
+	RSSI       int      // Percent, -1 when unknown
+}
+
+// Telemetry is the flight controller state shared between the telemetry
+// reader and the OSD. Safe for concurrent use.
+type Telemetry struct {
+	mu    sync.Mutex
+	state TelemetryState
+}
+
+// NewTelemetry creates an empty telemetry model
+func NewTelemetry() *Telemetry {
+	return &Telemetry{}
+}
+
+// Update applies a decoded message to the state
+func (t *Telemetry) Update(apply func(state *TelemetryState)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	apply(&t.state)
+	t.state.Updated = time.Now()
+	t.state.Messages++
+}
+
+// Snapshot returns a copy of the current state
+func (t *Telemetry) Snapshot() TelemetryState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.state
+	state.RCChannels = append([]uint16(nil), t.state.RCChannels...)
+	return state
+}
+
+// TelemetryEndpoint is a flight controller link: a serial port or a UDP port
+// the flight controller (or a router such as mavlink-router) sends to
+type TelemetryEndpoint struct {
+	Scheme  string // serial or udp
+	Path    string // Serial device
+	Baud    int    // Serial baud rate
+	Address string // UDP listen address
+}
+
+// ParseTelemetryEndpoint parses serial:/dev/ttyS0[:BAUD], udp:PORT or udp:HOST:PORT
+func ParseTelemetryEndpoint(spec string) (TelemetryEndpoint, error) {
+	scheme, rest, ok := strings.Cut(spec, ":")
+	if !ok || rest == "" {
+		return TelemetryEndpoint{}, fmt.Errorf("invalid telemetry link %q, expected serial:/dev/ttyS0:115200 or udp:14550", spec)
+	}
+	switch scheme {
+	case "serial":
+		endpoint := TelemetryEndpoint{Scheme: scheme, Path: rest, Baud: defaultSerialBaud}
+		if i := strings.LastIndex(rest, ":"); i >= 0 {
+			baud, err := strconv.Atoi(rest[i+1:])
+			if err != nil || baud <= 0 {
+				return TelemetryEndpoint{}, fmt.Errorf("invalid baud rate in %q", spec)
+			}
+			endpoint.Path, endpoint.Baud = rest[:i], baud
+		}
+		return endpoint, nil
+	case "udp":
+		address := rest
+		if !strings.Contains(rest, ":") {
+			address = ":" + rest
+		}
+		_, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return TelemetryEndpoint{}, fmt.Errorf("invalid telemetry UDP address %q: %w", rest, err)
+		}
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return TelemetryEndpoint{}, fmt.Errorf("invalid telemetry UDP port: %s", port)
+		}
+		return TelemetryEndpoint{Scheme: scheme, Address: address}, nil
+	}
+	return TelemetryEndpoint{}, fmt.Errorf("unsupported telemetry link %q, expected serial: or udp:", scheme)
+}
+
+// String returns the endpoint in --mavlink form
+func (e TelemetryEndpoint) String() string {
+	if e.Scheme == "serial" {
+		return fmt.Sprintf("serial:%s:%d", e.Path, e.Baud)
+	}
+	return "udp:" + e.Address
+}
+
+// openTelemetryLink opens the endpoint for reading and writing
+func openTelemetryLink(endpoint TelemetryEndpoint) (io.ReadWriteCloser, error) {
+	if endpoint.Scheme == "serial" {
+		file, err := openSerial(endpoint.Path, endpoint.Baud)
+		if err != nil {
+			return nil, err
+		}
+		return file, nil
+	}
+	addr, err := net.ResolveUDPAddr("udp", endpoint.Address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &udpLink{conn: conn}, nil
+}
+
+// udpLink turns a UDP socket into a byte stream. Reads return whole datagrams
+// piecewise, writes go to the peer that sent the last datagram.
+type udpLink struct {
+	conn    *net.UDPConn
+	buf     [65536]byte
+	pending []byte
+
+	mu   sync.Mutex
+	peer *net.UDPAddr
+}
+
+func (l *udpLink) Read(p []byte) (int, error) {
+	for len(l.pending) == 0 {
+		n, peer, err := l.conn.ReadFromUDP(l.buf[:])
+		if err != nil {
+			return 0, err
+		}
+		l.mu.Lock()
+		l.peer = peer
+		l.mu.Unlock()
+		l.pending = l.buf[:n]
+	}
+	n := copy(p, l.pending)
+	l.pending = l.pending[n:]
+	return n, nil
+}
+
+func (l *udpLink) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	peer := l.peer
+	l.mu.Unlock()
+	if peer == nil {
+		return 0, fmt.Errorf("no telemetry peer yet")
+	}
+	return l.conn.WriteToUDP(p, peer)
+}
+
+func (l *udpLink) Close() error {
+	return l.conn.Close()
+}
+
+// telemetryProtocol decodes one protocol from a link into the telemetry model
+// until the link fails
+type telemetryProtocol func(link io.ReadWriter, telemetry *Telemetry) error
+
+// startTelemetry reads the configured telemetry link in the background,
+// reopening it after errors. It returns nil without a link, and a stop function.
+func startTelemetry(config StreamConfig) (*Telemetry, func()) {
+	if config.MAVLink == nil && config.MSP == nil {
+		return nil, func() {}
+	}
+	var endpoint TelemetryEndpoint
+	var protocol telemetryProtocol
+	var name string
+	if config.MSP != nil {
+		endpoint, name = *config.MSP, "MSP"
+		protocol = func(link io.ReadWriter, telemetry *Telemetry) error {
+			return pollMSP(link, telemetry, config.MSPRate)
+		}
+	} else {
+		endpoint, protocol, name = *config.MAVLink, readMAVLink, "MAVLink"
+	}
+
+	telemetry := NewTelemetry()
+	var mu sync.Mutex
+	var link io.ReadWriteCloser
+	stopped := false
+
+	go func() {
+		for {
+			l, err := openTelemetryLink(endpoint)
+			mu.Lock()
+			if stopped {
+				mu.Unlock()
+				if err == nil {
+					l.Close()
+				}
+				return
+			}
+			if err == nil {
+				link = l
+			}
+			mu.Unlock()
+
+			if err == nil {
+				fmt.Printf("[telemetry] reading %s from %s\n", name, endpoint)
+				err = protocol(l, telemetry)
+				l.Close()
+			}
+
+			mu.Lock()
+			done := stopped
+			mu.Unlock()
+			if done {
+				return
+			}
+			fmt.Printf("[telemetry] %s: %v, retrying in %s\n", endpoint, err, telemetryRetryInterval)
+			time.Sleep(telemetryRetryInterval)
+		}
+	}()
+
+	return telemetry, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+		if link != nil {
+			link.Close()
+		}
+	}
+}
+
+// telemetryOSDText renders a telemetry OSD element from the state
+func telemetryOSDText(kind OSDKind, state TelemetryState) string {
+	stale := state.Updated.IsZero() || time.Since(state.Updated) > telemetryStaleAfter
+	switch kind {
+	case OSDHorizon:
+		if stale || !state.HasAttitude {
+			return "NO TELEMETRY"
+		}
+		return renderHorizon(state.Roll, state.Pitch)
+	case OSDAltitude:
+		switch {
+		case stale:
+			return "ALT --"
+		case state.HasAltitude:
+			return fmt.Sprintf("ALT %.0fm %+.1fm/s", state.Altitude, state.Climb)
+		case state.HasGPS && state.GPSFix >= 3:
+			return fmt.Sprintf("ALT %.0fm", state.GPSAltitude)
+		}
+		return "ALT --"
+	case OSDSpeed:
+		switch {
+		case stale:
+			return "SPD --"
+		case state.HasHUD:
+			return fmt.Sprintf("SPD %.0fkm/h HDG %03d", state.Groundspeed*3.6, state.Heading)
+		case state.HasGPS && state.GPSFix >= 2:
+			return fmt.Sprintf("SPD %.0fkm/h", state.GPSSpeed*3.6)
+		}
+		return "SPD --"
+	case OSDBattery:
+		if stale || !state.HasBattery {
+			return "BAT --"
+		}
+		text := fmt.Sprintf("BAT %.1fV", state.Voltage)
+		if state.Current >= 0 {
+			text += fmt.Sprintf(" %.1fA", state.Current)
+		}
+		if state.BatteryRemaining >= 0 {
+			text += fmt.Sprintf(" %d%%", state.BatteryRemaining)
+		}
+		return text
+	case OSDGPS:
+		if stale || !state.HasGPS {
+			return "GPS --"
+		}
+		fix := "NO FIX"
+		switch {
+		case state.GPSFix == 2:
+			fix = "2D"
+		case state.GPSFix >= 3:
+			fix = "3D"
+		}
+		text := "GPS " + fix
+		if state.Satellites >= 0 {
+			text += fmt.Sprintf(" %dsat", state.Satellites)
+		}
+		if state.GPSFix >= 2 {
+			text += fmt.Sprintf(" %.5f %.5f", state.Latitude, state.Longitude)
+		}
+		return text
+	}
+	return ""
+}
+
+// Artificial horizon grid, in characters. Monospace cells are about twice as
+// tall as wide, which the slope accounts for.
+const (
+	horizonColumns      = 25
+	horizonRows         = 9
+	horizonPitchPerRow  = 5.0 // Degrees of pitch per row
+	horizonCellAspect   = 2.0
+	horizonMaxRollSlope = 8.0
+)
+
+// renderHorizon draws the horizon line as text, like character OSDs do: the
+// line moves down when pitching up and tilts against the roll
+func renderHorizon(roll, pitch float64) string {
+	grid := make([][]byte, horizonRows)
+	for i := range grid {
+		grid[i] = []byte(strings.Repeat(" ", horizonColumns))
+	}
+
+	slope := math.Tan(roll * math.Pi / 180)
+	slope = math.Max(-horizonMaxRollSlope, math.Min(horizonMaxRollSlope, slope))
+	char := byte('-')
+	switch {
+	case slope > 0.3:
+		char = '/'
+	case slope < -0.3:
+		char = '\\'
+	}
+
+	center := float64(horizonRows-1) / 2
+	offset := pitch / horizonPitchPerRow
+	for col := 0; col < horizonColumns; col++ {
+		x := float64(col - horizonColumns/2)
+		row := int(math.Round(center + offset - x*slope/horizonCellAspect))
+		if row >= 0 && row < horizonRows {
+			grid[row][col] = char
+		}
+	}
+
+	lines := make([]string, horizonRows)
+	for i, row := range grid {
+		lines[i] = string(row)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// telemetryStats reports the telemetry link health and the main values
+func telemetryStats(telemetry *Telemetry) func() map[string]any {
+	return func() map[string]any {
+		state := telemetry.Snapshot()
+		if state.Updated.IsZero() {
+			return map[string]any{"messages": 0}
+		}
+		stats := map[string]any{
+			"messages": state.Messages,
+			"age":      time.Since(state.Updated).Round(time.Millisecond),
+		}
+		if state.HasAttitude {
+			stats["attitude"] = fmt.Sprintf("%.0f/%.0f/%.0f", state.Roll, state.Pitch, state.Yaw)
+		}
+		if state.HasBattery {
+			stats["voltage"] = fmt.Sprintf("%.2fV", state.Voltage)
+		}
+		if state.HasGPS {
+			stats["sats"] = state.Satellites
+		}
+		return stats
+	}
+}
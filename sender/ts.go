@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-gst/go-glib/glib"
+	"github.com/go-gst/go-gst/examples"
+	"github.com/go-gst/go-gst/gst"
+)
+
+// ContainerType selects how video and audio are carried
+type ContainerType string
+
+const (
+	ContainerRTP    ContainerType = "rtp"    // Separate RTP streams for video and audio
+	ContainerMPEGTS ContainerType = "mpegts" // One MPEG-TS stream on the video port
+)
+
+// mp2tPayloadType is the static RTP payload type of MPEG-TS (RFC 3551)
+const mp2tPayloadType = 33
+
+// tsPacketsPerDatagram is the number of 188-byte TS packets per UDP datagram,
+// the largest count fitting a 1500-byte MTU (7 x 188 = 1316 bytes)
+const tsPacketsPerDatagram = 7
+
+// ValidateContainer checks the container
+func ValidateContainer(container string) (ContainerType, error) {
+	switch ContainerType(container) {
+	case ContainerRTP, ContainerMPEGTS:
+	default:
+		return "", fmt.Errorf("unsupported container: %s (supported: %s, %s)", container, ContainerRTP, ContainerMPEGTS)
+	}
+	return ContainerType(container), nil
+}
+
+// ValidateTSAudio checks that the audio codec can be carried in MPEG-TS
+func ValidateTSAudio(codec AudioCodec) error {
+	switch codec {
+	case AudioOpus, AudioAAC:
+		return nil
+	}
+	return fmt.Errorf("%s audio cannot be carried in MPEG-TS, use --audio-codec opus or aac", codec)
+}
+
+// ValidateTSCodec checks that the codec can be carried in MPEG-TS
+func ValidateTSCodec(family CodecFamily) error {
+	switch family {
+	case CodecH264, CodecH265:
+		return nil
+	}
+	return fmt.Errorf("%s cannot be carried in MPEG-TS, use an H.264 or H.265 encoder", family)
+}
+
+// BuildTSPipeline builds a single pipeline muxing the encoded video and Opus or
+// AAC audio into MPEG-TS. Both branches share the pipeline clock, so the muxer
+// stamps audio and video consistently.
+func BuildTSPipeline(config StreamConfig) (*gst.Pipeline, error) {
+	pipeline, err := gst.NewPipeline("ts-pipeline")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pipeline: %w", err)
+	}
+
+	// Muxer and output
+	mux, err := gst.NewElementWithName("mpegtsmux", "ts-mux")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mpegtsmux: %w", err)
+	}
+	mux.SetProperty("alignment", tsPacketsPerDatagram)
+	sink, err := createTSSink(config)
+	if err != nil {
+		return nil, err
+	}
+	output := append([]*gst.Element{mux}, sink...)
+	if err := addAndLinkElements(pipeline, output); err != nil {
+		return nil, err
+	}
+
+	// Video: capture and encode, then repeat SPS/PPS so receivers can join mid-stream
+	if !config.NoVideo {
+		video, err := buildVideoEncodeChain(config)
+		if err != nil {
+			return nil, err
+		}
+		parser, _, err := createVideoParserPayloader(config.Encoder, videoPayloaderName)
+		if err != nil {
+			return nil, err
+		}
+		parser.SetProperty("config-interval", -1)
+		videoQueue, _ := gst.NewElement("queue")
+		video = append(video, parser, videoQueue)
+		if err := addAndLinkElements(pipeline, video); err != nil {
+			return nil, err
+		}
+		if err := videoQueue.Link(mux); err != nil {
+			return nil, fmt.Errorf("failed to link video to mpegtsmux: %w", err)
+		}
+	}
+
+	// Audio: capture and encode
+	if !config.NoAudio {
+		audio, err := buildAudioEncodeChain(config)
+		if err != nil {
+			return nil, err
+		}
+		audioQueue, _ := gst.NewElement("queue")
+		audio = append(audio, audioQueue)
+		if err := addAndLinkElements(pipeline, audio); err != nil {
+			return nil, err
+		}
+		if err := audioQueue.Link(mux); err != nil {
+			return nil, fmt.Errorf("failed to link audio to mpegtsmux: %w", err)
+		}
+	}
+
+	return pipeline, nil
+}
+
+// createAACEncoder creates the best available AAC encoder
+func createAACEncoder(bitrate int) (*gst.Element, error) {
+	for _, name := range []string{"fdkaacenc", "avenc_aac"} {
+		if enc, err := gst.NewElement(name); err == nil {
+			enc.SetProperty("bitrate", bitrate)
+			return enc, nil
+		}
+	}
+	return nil, fmt.Errorf("no AAC encoder found (install fdkaacenc or gst-libav for avenc_aac)")
+}
+
+// aacEncoderCommand returns the AAC encoder name used in printed commands
+func aacEncoderCommand() string {
+	if gst.Find("fdkaacenc") != nil {
+		return "fdkaacenc"
+	}
+	return "avenc_aac"
+}
+
+// createTSSink creates the elements after the muxer for the configured
+// transport: srtsink, or a multiudpsink on each destination's video port,
+// optionally behind an RTP MP2T payloader
+func createTSSink(config StreamConfig) ([]*gst.Element, error) {
+	if config.SRTURI != "" {
+		sink, err := createSRTSink(config)
+		if err != nil {
+			return nil, err
+		}
+		return []*gst.Element{sink}, nil
+	}
+
+	var elements []*gst.Element
+	if config.TSRTP {
+		pay, err := gst.NewElement("rtpmp2tpay")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create rtpmp2tpay: %w", err)
+		}
+		configurePayloader(pay, config.SSRC, mp2tPayloadType)
+		elements = append(elements, pay)
+	}
+	sink, _ := gst.NewElementWithName("multiudpsink", "video-sink")
+	sink.SetProperty("clients", buildClients(config.Destinations, videoPort))
+	sink.SetProperty("sync", false)
+	sink.SetProperty("async", false)
+	return append(elements, sink), nil
+}
+
+// buildTSSinkCommand returns the gst-launch fragment after the muxer
+func buildTSSinkCommand(config StreamConfig) string {
+	if config.SRTURI != "" {
+		return buildSRTSinkCommand(config)
+	}
+	sink := fmt.Sprintf("multiudpsink name=video-sink clients=%s sync=false async=false", buildClients(config.Destinations, videoPort))
+	if config.TSRTP {
+		return "rtpmp2tpay" + payloaderCommandProps(config.SSRC, mp2tPayloadType) + " ! " + sink
+	}
+	return sink
+}
+
+// BuildTSPipelineCommand generates a gst-launch-1.0 command that mirrors the
+// pipeline built by BuildTSPipeline
+func BuildTSPipelineCommand(config StreamConfig) string {
+	fragments := []string{
+		fmt.Sprintf("mpegtsmux name=ts-mux alignment=%d ! %s", tsPacketsPerDatagram, buildTSSinkCommand(config)),
+	}
+
+	// Reuse the RTP chains without their payloaders
+	if !config.NoVideo {
+		video := buildVideoChainCommand(config)
+		video = video[:len(video)-1]
+		video[len(video)-1] += " config-interval=-1"
+		video = append(video, "queue", "ts-mux.")
+		fragments = append(fragments, strings.Join(video, " ! \\\n    "))
+	}
+	if !config.NoAudio {
+		audio := buildAudioChainCommand(config)
+		audio = audio[:len(audio)-1]
+		audio = append(audio, "queue", "ts-mux.")
+		fragments = append(fragments, strings.Join(audio, " ! \\\n    "))
+	}
+	return "GST_DEBUG=2 gst-launch-1.0 -v -e " + strings.Join(fragments, " \\\n  ")
+}
+
+// runTSPipeline runs the single MPEG-TS pipeline until EOS, error or Ctrl+C
+func runTSPipeline(config StreamConfig) error {
+	if !config.NoVideo {
+		if err := ValidateTSCodec(GetCodecFamily(config.Encoder)); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Pipeline command:")
+	fmt.Println()
+	fmt.Println(BuildTSPipelineCommand(config))
+	fmt.Println()
+
+	fmt.Println("Building MPEG-TS pipeline...")
+	pipeline, err := BuildTSPipeline(config)
+	if err != nil {
+		return fmt.Errorf("failed to create MPEG-TS pipeline: %w", err)
+	}
+	fmt.Println()
+
+	var runErr error
+	examples.RunLoop(func(mainLoop *glib.MainLoop) error {
+		addPipelineWatch(pipeline, "ts", mainLoop, []*gst.Pipeline{pipeline})
+
+		// UDP destinations can be added/removed at runtime from stdin
+		if config.SRTURI == "" {
+			dests := NewDestinationSet(config, pipeline, nil)
+			go runConsole(os.Stdin, dests)
+		}
+
+		// RTP-wrapped MPEG-TS needs an SDP for players like VLC
+		if config.SDPFile != "" && config.TSRTP {
+			if err := NewSDPWriter(config.SDPFile, config).Write(nil); err != nil {
+				return err
+			}
+			fmt.Printf("Writing SDP to %s\n", config.SDPFile)
+		}
+
+		telemetry, stopTelemetry := startTelemetry(config)
+		defer stopTelemetry()
+
+		if config.StatsInterval > 0 {
+			stats := NewStatsReporter()
+			if telemetry != nil {
+				stats.Add("telemetry", telemetryStats(telemetry))
+			}
+			if config.SRTURI != "" {
+				stats.Add("srt", srtStats(pipeline))
+			}
+			stats.Start(config.StatsInterval)
+		}
+
+		if config.OSD.Enabled() && !config.NoVideo {
+			if err := startOSD(config, pipeline, telemetry); err != nil {
+				return err
+			}
+		}
+
+		fmt.Println("Starting pipeline...")
+		pipeline.SetState(gst.StatePlaying)
+		fmt.Println("Streaming... Press Ctrl+C to stop.")
+
+		runErr = mainLoop.RunError()
+		return runErr
+	})
+	return runErr
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/go-gst/go-gst/gst/gstwebrtc"
+)
+
+// webrtcNegotiationTimeout bounds how long webrtcbin may take to answer a promise
+const webrtcNegotiationTimeout = 10 * time.Second
+
+// webrtcVideoCaps returns the RTP caps of the video stream. webrtcbin needs
+// them on its sink pad to build an offer before any data has flowed.
+func webrtcVideoCaps(config StreamConfig) string {
+	return fmt.Sprintf("application/x-rtp,media=video,encoding-name=%s,payload=%d,clock-rate=%d",
+		GetCodecFamily(config.Encoder), config.PayloadType, rtpClockRate)
+}
+
+// webrtcAudioCaps returns the RTP caps of the audio stream
+func webrtcAudioCaps(config StreamConfig) string {
+	return fmt.Sprintf("application/x-rtp,media=audio,encoding-name=%s,payload=%d,clock-rate=%d",
+		audioCodecInfos[config.Audio.Codec].EncodingName, audioPayloadType(config.Audio), audioClockRate(config.Audio))
+}
+
+// ValidateWebRTCAudio checks that browsers can decode the audio codec
+func ValidateWebRTCAudio(codec AudioCodec) error {
+	switch codec {
+	case AudioOpus, AudioPCMU, AudioPCMA:
+		return nil
+	}
+	return fmt.Errorf("WebRTC carries only opus, pcmu or pcma audio, got: %s", codec)
+}
+
+// newWebRTCBin creates a webrtcbin bundling all media on one transport
+func newWebRTCBin(name, stunServer string) (*gst.Element, error) {
+	webrtc, err := gst.NewElementWithName("webrtcbin", name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webrtcbin: %w", err)
+	}
+	webrtc.SetArg("bundle-policy", "max-bundle")
+	if stunServer != "" {
+		webrtc.SetProperty("stun-server", stunServer)
+	}
+	return webrtc, nil
+}
+
+// newRTPCapsFilter creates a capsfilter fixing the RTP caps in front of webrtcbin
+func newRTPCapsFilter(caps string) *gst.Element {
+	filter, _ := gst.NewElement("capsfilter")
+	filter.SetProperty("caps", gst.NewCapsFromString(caps))
+	return filter
+}
+
+// linkSendOnly links src to a new webrtcbin sink pad and marks its
+// transceiver send-only, since the sender never receives media
+func linkSendOnly(src, webrtc *gst.Element, padName string) error {
+	if err := linkPads(src, "src", webrtc, padName); err != nil {
+		return err
+	}
+	value, err := webrtc.GetStaticPad(padName).GetProperty("transceiver")
+	if err != nil {
+		return fmt.Errorf("failed to get transceiver of %s: %w", padName, err)
+	}
+	if transceiver, ok := value.(*gstwebrtc.RTPTransceiver); ok {
+		transceiver.SetArg("direction", "sendonly")
+	}
+	return nil
+}
+
+// createOffer creates an SDP offer and sets it as the local description.
+// Blocks until webrtcbin answers, so it must not run on the GLib main loop.
+func createOffer(webrtc *gst.Element) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), webrtcNegotiationTimeout)
+	defer cancel()
+
+	promise := gst.NewPromise()
+	if _, err := webrtc.Emit("create-offer", gst.NewStructure("options"), promise); err != nil {
+		return "", fmt.Errorf("failed to create offer: %w", err)
+	}
+	reply, err := promise.Await(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to create offer: %w", err)
+	}
+	value, err := reply.GetValue("offer")
+	if err != nil {
+		return "", fmt.Errorf("failed to create offer: %w", err)
+	}
+	offer, ok := value.(*gstwebrtc.SessionDescription)
+	if !ok {
+		return "", fmt.Errorf("failed to create offer: unexpected reply %s", reply)
+	}
+
+	if _, err := webrtc.Emit("set-local-description", offer, gst.NewPromise()); err != nil {
+		return "", fmt.Errorf("failed to set local description: %w", err)
+	}
+	return offer.SDP().String(), nil
+}
+
+// setRemoteAnswer sets an SDP answer as the remote description
+func setRemoteAnswer(webrtc *gst.Element, sdp string) error {
+	answer, err := (&gstwebrtc.W3RTCSessionDescription{Type: "answer", Sdp: sdp}).ToGstSDP()
+	if err != nil {
+		return fmt.Errorf("invalid SDP answer: %w", err)
+	}
+	if _, err := webrtc.Emit("set-remote-description", answer, gst.NewPromise()); err != nil {
+		return fmt.Errorf("failed to set remote description: %w", err)
+	}
+	return nil
+}
+
+// sdpICEInfo holds the ICE credentials and media IDs of a local description,
+// needed to address trickled candidates
+type sdpICEInfo struct {
+	UFrag  string
+	Pwd    string
+	Mids   []string // Indexed by m-line
+	MLines []string // The m= line of each media section, indexed like Mids
+}
+
+// parseSDPICEInfo extracts the ICE credentials and media IDs from an SDP
+func parseSDPICEInfo(sdp string) sdpICEInfo {
+	var info sdpICEInfo
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "m="):
+			info.Mids = append(info.Mids, fmt.Sprint(len(info.Mids)))
+			info.MLines = append(info.MLines, line)
+		case strings.HasPrefix(line, "a=mid:") && len(info.Mids) > 0:
+			info.Mids[len(info.Mids)-1] = strings.TrimPrefix(line, "a=mid:")
+		case strings.HasPrefix(line, "a=ice-ufrag:") && info.UFrag == "":
+			info.UFrag = strings.TrimPrefix(line, "a=ice-ufrag:")
+		case strings.HasPrefix(line, "a=ice-pwd:") && info.Pwd == "":
+			info.Pwd = strings.TrimPrefix(line, "a=ice-pwd:")
+		}
+	}
+	return info
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-gst/go-glib/glib"
+	"github.com/go-gst/go-gst/examples"
+	"github.com/go-gst/go-gst/gst"
+)
+
+// whipHTTPTimeout bounds each request to the WHIP endpoint
+const whipHTTPTimeout = 10 * time.Second
+
+// whipCandidate is a local ICE candidate gathered by webrtcbin
+type whipCandidate struct {
+	MLine     uint
+	Candidate string
+}
+
+// WHIPClient publishes a WebRTC session to a WHIP endpoint (RFC 9725): the
+// offer is POSTed to the endpoint, candidates are trickled to the returned
+// session resource with PATCH, and the resource is DELETEd on shutdown.
+type WHIPClient struct {
+	endpoint string
+	token    string
+	client   *http.Client
+
+	mu        sync.Mutex
+	resource  string // Session URL, empty until the offer is answered
+	etag      string
+	ice       sdpICEInfo
+	pending   []whipCandidate
+	noTrickle bool
+}
+
+// NewWHIPClient creates a client for a WHIP endpoint with an optional bearer token
+func NewWHIPClient(endpoint, token string) *WHIPClient {
+	return &WHIPClient{
+		endpoint: endpoint,
+		token:    token,
+		client:   &http.Client{Timeout: whipHTTPTimeout},
+	}
+}
+
+// ValidateWHIPURL checks that a WHIP endpoint is an absolute HTTP(S) URL
+func ValidateWHIPURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid WHIP URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("WHIP URL must be http:// or https://, got: %s", raw)
+	}
+	return nil
+}
+
+// Offer POSTs the SDP offer and returns the SDP answer. Candidates gathered
+// while waiting are sent once the session resource is known.
+func (c *WHIPClient) Offer(offer string) (string, error) {
+	c.mu.Lock()
+	c.ice = parseSDPICEInfo(offer)
+	c.mu.Unlock()
+
+	req, err := c.newRequest(http.MethodPost, c.endpoint, "application/sdp", offer)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("WHIP offer failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("WHIP offer failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("WHIP endpoint returned no Location header")
+	}
+	resource, err := resolveURL(c.endpoint, location)
+	if err != nil {
+		return "", fmt.Errorf("invalid WHIP Location %q: %w", location, err)
+	}
+
+	c.mu.Lock()
+	c.resource = resource
+	c.etag = resp.Header.Get("ETag")
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+
+	fmt.Printf("[whip] session %s\n", resource)
+	if len(pending) > 0 {
+		go c.trickle(pending)
+	}
+	return string(body), nil
+}
+
+// AddCandidate trickles a local ICE candidate to the session, or queues it
+// until the offer has been answered
+func (c *WHIPClient) AddCandidate(mline uint, candidate string) {
+	c.mu.Lock()
+	if c.resource == "" {
+		c.pending = append(c.pending, whipCandidate{MLine: mline, Candidate: candidate})
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+	go c.trickle([]whipCandidate{{MLine: mline, Candidate: candidate}})
+}
+
+// trickle PATCHes candidates as an SDP fragment (RFC 8840). Endpoints without
+// trickle support answer 405 or 501; ICE then relies on the candidates the
+// endpoint learns from connectivity checks.
+func (c *WHIPClient) trickle(candidates []whipCandidate) {
+	c.mu.Lock()
+	if c.noTrickle {
+		c.mu.Unlock()
+		return
+	}
+	resource, etag := c.resource, c.etag
+	fragment := buildTrickleFragment(c.ice, candidates)
+	c.mu.Unlock()
+
+	req, err := c.newRequest(http.MethodPatch, resource, "application/trickle-ice-sdpfrag", fragment)
+	if err != nil {
+		return
+	}
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		fmt.Printf("[whip] failed to send ICE candidates: %v\n", err)
+		return
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK:
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		c.mu.Lock()
+		c.noTrickle = true
+		c.mu.Unlock()
+		fmt.Println("[whip] endpoint does not support trickle ICE")
+	default:
+		fmt.Printf("[whip] failed to send ICE candidates: %s\n", resp.Status)
+	}
+}
+
+// Close DELETEs the session resource so the endpoint can release it at once
+// instead of waiting for ICE to time out
+func (c *WHIPClient) Close() error {
+	c.mu.Lock()
+	resource := c.resource
+	c.resource = ""
+	c.mu.Unlock()
+	if resource == "" {
+		return nil
+	}
+
+	req, err := c.newRequest(http.MethodDelete, resource, "", "")
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("WHIP teardown failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("WHIP teardown failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// newRequest creates a request with the bearer token and an optional body
+func (c *WHIPClient) newRequest(method, target, contentType, body string) (*http.Request, error) {
+	req, err := http.NewRequest(method, target, strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WHIP request: %w", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// resolveURL resolves a possibly relative reference against base
+func resolveURL(base, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return b.ResolveReference(r).String(), nil
+}
+
+// buildTrickleFragment builds the SDP fragment of a trickle PATCH: the ICE
+// credentials, then each media section with candidates, in m-line order, as
+// its m= line of the offer with the discard port, its mid and its candidates
+func buildTrickleFragment(ice sdpICEInfo, candidates []whipCandidate) string {
+	var lines []string
+	add := func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	add("a=ice-ufrag:%s", ice.UFrag)
+	add("a=ice-pwd:%s", ice.Pwd)
+	for mline, mid := range ice.Mids {
+		var media []string
+		for _, cand := range candidates {
+			if int(cand.MLine) == mline {
+				media = append(media, "a="+strings.TrimPrefix(cand.Candidate, "a="))
+			}
+		}
+		if len(media) == 0 {
+			continue
+		}
+		add("%s", trickleMLine(ice.MLines[mline]))
+		add("a=mid:%s", mid)
+		lines = append(lines, media...)
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// trickleMLine returns an m= line with port 9, which RFC 8840 uses for the
+// media sections of a trickle fragment
+func trickleMLine(mline string) string {
+	fields := strings.Fields(mline)
+	if len(fields) < 2 {
+		return mline
+	}
+	fields[1] = "9"
+	return strings.Join(fields, " ")
+}
+
+// BuildWHIPPipeline builds a single pipeline feeding the encoded video and
+// audio into webrtcbin, which handles ICE, DTLS-SRTP and RTCP itself
+func BuildWHIPPipeline(config StreamConfig) (*gst.Pipeline, *gst.Element, error) {
+	pipeline, err := gst.NewPipeline("whip-pipeline")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create pipeline: %w", err)
+	}
+
+	webrtc, err := newWebRTCBin("whip-webrtc", config.STUNServer)
+	if err != nil {
+		return nil, nil, err
+	}
+	pipeline.Add(webrtc)
+
+	// One chain per present stream, on consecutive webrtcbin sink pads
+	var chains [][]*gst.Element
+
+	// Video: capture, encode and payload
+	if !config.NoVideo {
+		video, err := buildVideoEncodeChain(config)
+		if err != nil {
+			return nil, nil, err
+		}
+		parser, payloader, err := createVideoParserPayloader(config.Encoder, videoPayloaderName)
+		if err != nil {
+			return nil, nil, err
+		}
+		configurePayloader(payloader, config.SSRC, config.PayloadType)
+		if parser != nil {
+			video = append(video, parser)
+		}
+		video = append(video, payloader, newRTPCapsFilter(webrtcVideoCaps(config)))
+		chains = append(chains, video)
+	}
+
+	// Audio: capture, encode and payload
+	if !config.NoAudio {
+		audio, err := buildAudioEncodeChain(config)
+		if err != nil {
+			return nil, nil, err
+		}
+		audioPay, err := createAudioPayloader(config)
+		if err != nil {
+			return nil, nil, err
+		}
+		audio = append(audio, audioPay, newRTPCapsFilter(webrtcAudioCaps(config)))
+		chains = append(chains, audio)
+	}
+
+	for i, chain := range chains {
+		if err := addAndLinkElements(pipeline, chain); err != nil {
+			return nil, nil, err
+		}
+		if err := linkSendOnly(chain[len(chain)-1], webrtc, fmt.Sprintf("sink_%d", i)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return pipeline, webrtc, nil
+}
+
+// negotiateWHIP creates the offer, exchanges it with the WHIP endpoint and
+// applies the answer
+func negotiateWHIP(webrtc *gst.Element, client *WHIPClient) error {
+	offer, err := createOffer(webrtc)
+	if err != nil {
+		return err
+	}
+	answer, err := client.Offer(offer)
+	if err != nil {
+		return err
+	}
+	return setRemoteAnswer(webrtc, answer)
+}
+
+// runWHIPPipeline publishes to the WHIP endpoint until EOS, error or Ctrl+C,
+// then tears down the session
+func runWHIPPipeline(config StreamConfig) error {
+	fmt.Printf("Building WHIP pipeline for %s...\n", config.WHIPURL)
+	pipeline, webrtc, err := BuildWHIPPipeline(config)
+	if err != nil {
+		return fmt.Errorf("failed to create WHIP pipeline: %w", err)
+	}
+	fmt.Println()
+
+	client := NewWHIPClient(config.WHIPURL, config.WHIPToken)
+	defer func() {
+		if err := client.Close(); err != nil {
+			fmt.Printf("[whip] %v\n", err)
+		}
+	}()
+
+	var runErr error
+	examples.RunLoop(func(mainLoop *glib.MainLoop) error {
+		addPipelineWatch(pipeline, "whip", mainLoop, []*gst.Pipeline{pipeline})
+
+		// Negotiate once webrtcbin has both transceivers; the HTTP exchange
+		// blocks, so it runs off the main loop
+		var once sync.Once
+		webrtc.Connect("on-negotiation-needed", func(self *gst.Element) {
+			once.Do(func() {
+				go func() {
+					if err := negotiateWHIP(self, client); err != nil {
+						glib.IdleAdd(func() bool {
+							runErr = err
+							mainLoop.Quit()
+							return false
+						})
+					}
+				}()
+			})
+		})
+		webrtc.Connect("on-ice-candidate", func(self *gst.Element, mline uint, candidate string) {
+			client.AddCandidate(mline, candidate)
+		})
+
+		// Stop on Ctrl+C so the session resource is deleted on the way out
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(sigs)
+		go func() {
+			<-sigs
+			fmt.Println("\nStopping...")
+			mainLoop.Quit()
+		}()
+
+		telemetry, stopTelemetry := startTelemetry(config)
+		defer stopTelemetry()
+		if config.OSD.Enabled() && !config.NoVideo {
+			if err := startOSD(config, pipeline, telemetry); err != nil {
+				return err
+			}
+		}
+
+		fmt.Println("Starting pipeline...")
+		pipeline.SetState(gst.StatePlaying)
+		fmt.Println("Streaming... Press Ctrl+C to stop.")
+
+		if err := mainLoop.RunError(); err != nil && runErr == nil {
+			runErr = err
+		}
+		pipeline.BlockSetState(gst.StateNull)
+		return runErr
+	})
+	return runErr
+}
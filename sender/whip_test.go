@@ -0,0 +1,187 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testWHIPOffer = "v=0\r\n" +
+	"o=- 1 0 IN IP4 0.0.0.0\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"a=ice-ufrag:ufrag1\r\n" +
+	"a=ice-pwd:pwd1\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+	"a=mid:video0\r\n" +
+	"a=sendonly\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+	"a=mid:audio1\r\n" +
+	"a=sendonly\r\n"
+
+const testWHIPAnswer = "v=0\r\no=- 2 0 IN IP4 0.0.0.0\r\ns=-\r\nt=0 0\r\n"
+
+// whipEndpoint is a WHIP endpoint recording the requests it receives
+type whipEndpoint struct {
+	t       *testing.T
+	patches chan string
+	deleted chan struct{}
+}
+
+func newWHIPEndpoint(t *testing.T) *whipEndpoint {
+	return &whipEndpoint{t: t, patches: make(chan string, 10), deleted: make(chan struct{}, 1)}
+}
+
+func (e *whipEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+		e.t.Errorf("%s %s: Authorization = %q, want %q", r.Method, r.URL.Path, got, "Bearer secret")
+	}
+	body, _ := io.ReadAll(r.Body)
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/whip/endpoint":
+		if got := r.Header.Get("Content-Type"); got != "application/sdp" {
+			e.t.Errorf("POST Content-Type = %q, want application/sdp", got)
+		}
+		if string(body) != testWHIPOffer {
+			e.t.Errorf("POST body = %q, want the offer", body)
+		}
+		w.Header().Set("Location", "/whip/session/1")
+		w.Header().Set("ETag", `"e1"`)
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, testWHIPAnswer)
+	case r.Method == http.MethodPatch && r.URL.Path == "/whip/session/1":
+		if got := r.Header.Get("Content-Type"); got != "application/trickle-ice-sdpfrag" {
+			e.t.Errorf("PATCH Content-Type = %q, want application/trickle-ice-sdpfrag", got)
+		}
+		if got := r.Header.Get("If-Match"); got != `"e1"` {
+			e.t.Errorf("PATCH If-Match = %q, want the ETag of the session", got)
+		}
+		e.patches <- string(body)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && r.URL.Path == "/whip/session/1":
+		e.deleted <- struct{}{}
+		w.WriteHeader(http.StatusOK)
+	default:
+		e.t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (e *whipEndpoint) nextPatch(t *testing.T) string {
+	t.Helper()
+	select {
+	case body := <-e.patches:
+		return body
+	case <-time.After(5 * time.Second):
+		t.Fatal("no PATCH received")
+		return ""
+	}
+}
+
+func TestWHIPClientSession(t *testing.T) {
+	endpoint := newWHIPEndpoint(t)
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	client := NewWHIPClient(server.URL+"/whip/endpoint", "secret")
+
+	// Gathered before the answer: queued, then sent with the session known
+	client.AddCandidate(0, "candidate:1 1 UDP 2122260223 192.168.1.2 50000 typ host")
+
+	answer, err := client.Offer(testWHIPOffer)
+	if err != nil {
+		t.Fatalf("Offer: %v", err)
+	}
+	if answer != testWHIPAnswer {
+		t.Errorf("answer = %q, want %q", answer, testWHIPAnswer)
+	}
+	if want := server.URL + "/whip/session/1"; client.resource != want {
+		t.Errorf("session resource = %q, want the resolved Location %q", client.resource, want)
+	}
+
+	queued := endpoint.nextPatch(t)
+	for _, want := range []string{
+		"a=ice-ufrag:ufrag1\r\n",
+		"a=ice-pwd:pwd1\r\n",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\na=mid:video0\r\na=candidate:1 1 UDP 2122260223 192.168.1.2 50000 typ host\r\n",
+	} {
+		if !strings.Contains(queued, want) {
+			t.Errorf("queued PATCH %q does not contain %q", queued, want)
+		}
+	}
+	if strings.Contains(queued, "m=audio") {
+		t.Errorf("queued PATCH %q has a media section without candidates", queued)
+	}
+
+	// Gathered after the answer: trickled at once
+	client.AddCandidate(1, "a=candidate:2 1 UDP 2122260222 192.168.1.2 50001 typ host")
+	trickled := endpoint.nextPatch(t)
+	if want := "m=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=mid:audio1\r\na=candidate:2 1 UDP 2122260222 192.168.1.2 50001 typ host\r\n"; !strings.Contains(trickled, want) {
+		t.Errorf("PATCH %q does not contain %q", trickled, want)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	select {
+	case <-endpoint.deleted:
+	default:
+		t.Error("Close did not DELETE the session")
+	}
+	// A second Close has no session left to delete
+	if err := client.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestWHIPClientOfferRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad token", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client := NewWHIPClient(server.URL, "")
+	if _, err := client.Offer(testWHIPOffer); err == nil || !strings.Contains(err.Error(), "bad token") {
+		t.Errorf("Offer error = %v, want the endpoint's 401 reason", err)
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("Close without a session: %v", err)
+	}
+}
+
+func TestWHIPClientTrickleUnsupported(t *testing.T) {
+	var patches int
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			w.Header().Set("Location", "session")
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodPatch:
+			mu.Lock()
+			patches++
+			mu.Unlock()
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+
+	client := NewWHIPClient(server.URL+"/whip/", "")
+	if _, err := client.Offer(testWHIPOffer); err != nil {
+		t.Fatalf("Offer: %v", err)
+	}
+	client.trickle([]whipCandidate{{MLine: 0, Candidate: "candidate:1 1 UDP 1 10.0.0.1 1 typ host"}})
+	// The endpoint said no, later candidates are not sent
+	client.trickle([]whipCandidate{{MLine: 0, Candidate: "candidate:2 1 UDP 1 10.0.0.1 2 typ host"}})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if patches != 1 {
+		t.Errorf("PATCH requests = %d, want 1 before trickle is given up", patches)
+	}
+}
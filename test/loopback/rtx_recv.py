@@ -0,0 +1,110 @@
+#!/usr/bin/env python3
+"""RTX receiver: sends NACKs for lost video packets and reassembles the retransmissions.
+
+Receiver side for: udp --rtx --simulate-loss 0.05 x264enc VGA 127.0.0.1:5000. rtprtxreceive has to
+be attached through rtpbin's request-aux-receiver signal, which gst-launch cannot express, so this
+builds the pipeline with the GStreamer Python bindings. Every few seconds it prints how many
+retransmissions were requested and how many arrived, next to the sender's "[stats] video-rtx" line.
+"""
+
+import argparse
+import sys
+
+import gi
+
+gi.require_version("Gst", "1.0")
+from gi.repository import GLib, Gst  # noqa: E402
+
+# Must match the sender (sender/rtx.go)
+VIDEO_PAYLOAD_TYPE = 96
+VIDEO_RTX_PAYLOAD_TYPE = 123
+
+
+def make_rtx_receiver():
+    """Returns the bin rtpbin puts in front of the jitter buffer of the video session."""
+    rtx = Gst.ElementFactory.make("rtprtxreceive", "video-rtx")
+    pt_map, _ = Gst.Structure.from_string(
+        f"application/x-rtp-pt-map, {VIDEO_PAYLOAD_TYPE}=(uint){VIDEO_RTX_PAYLOAD_TYPE}")
+    rtx.set_property("payload-type-map", pt_map)
+
+    aux = Gst.Bin.new(None)
+    aux.add(rtx)
+    aux.add_pad(Gst.GhostPad.new("sink_0", rtx.get_static_pad("sink")))
+    aux.add_pad(Gst.GhostPad.new("src_0", rtx.get_static_pad("src")))
+    return aux, rtx
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__.splitlines()[0])
+    parser.add_argument("--host", default="127.0.0.1", help="sender address for the RTCP receiver reports")
+    parser.add_argument("--port", type=int, default=5000, help="video port")
+    parser.add_argument("--rtcp-sr-port", type=int, help="sender reports (default: port + 2)")
+    parser.add_argument("--rtcp-rr-port", type=int, help="receiver reports and NACKs (default: port + 4)")
+    parser.add_argument("--interval", type=int, default=5, help="seconds between stats lines")
+    parser.add_argument("--sink", default="autovideosink", help="video sink, fakesink for headless runs")
+    args = parser.parse_args()
+    sr_port = args.rtcp_sr_port or args.port + 2
+    rr_port = args.rtcp_rr_port or args.port + 4
+
+    Gst.init(None)
+    pipeline = Gst.parse_launch(
+        "rtpbin name=rtpbin rtp-profile=avpf do-retransmission=true latency=200 "
+        f'udpsrc port={args.port} caps="application/x-rtp, media=video, encoding-name=H264, '
+        f'clock-rate=90000, payload={VIDEO_PAYLOAD_TYPE}" ! rtpbin.recv_rtp_sink_0 '
+        f'udpsrc port={sr_port} caps="application/x-rtcp" ! rtpbin.recv_rtcp_sink_0 '
+        f"rtpbin.send_rtcp_src_0 ! udpsink host={args.host} port={rr_port} sync=false async=false"
+    )
+    rtpbin = pipeline.get_by_name("rtpbin")
+
+    rtx = None
+
+    def on_request_aux_receiver(_rtpbin, session):
+        nonlocal rtx
+        if session != 0:
+            return None
+        aux, rtx = make_rtx_receiver()
+        return aux
+
+    def on_pad_added(_rtpbin, pad):
+        if not pad.get_name().startswith("recv_rtp_src_0_"):
+            return
+        depay = Gst.parse_bin_from_description(
+            f"rtph264depay ! h264parse ! avdec_h264 ! videoconvert ! {args.sink} sync=false", True)
+        pipeline.add(depay)
+        depay.sync_state_with_parent()
+        pad.link(depay.get_static_pad("sink"))
+
+    rtpbin.connect("request-aux-receiver", on_request_aux_receiver)
+    rtpbin.connect("pad-added", on_pad_added)
+
+    loop = GLib.MainLoop()
+
+    def on_error(_bus, message):
+        err, debug = message.parse_error()
+        print(f"error from {message.src.get_name()}: {err.message} ({debug})", file=sys.stderr)
+        loop.quit()
+
+    def report():
+        if rtx is not None:
+            print(f"[rtx] requests={rtx.get_property('num-rtx-requests')} "
+                  f"packets={rtx.get_property('num-rtx-packets')} "
+                  f"associated={rtx.get_property('num-rtx-assoc-packets')}")
+        return GLib.SOURCE_CONTINUE
+
+    bus = pipeline.get_bus()
+    bus.add_signal_watch()
+    bus.connect("message::error", on_error)
+    GLib.timeout_add_seconds(args.interval, report)
+
+    pipeline.set_state(Gst.State.PLAYING)
+    try:
+        loop.run()
+    except KeyboardInterrupt:
+        pass
+    report()
+    pipeline.set_state(Gst.State.NULL)
+    return 0
+
+
+if __name__ == "__main__":
+    sys.exit(main())
@@ -0,0 +1,91 @@
+#!/usr/bin/env python3
+"""ULPFEC loopback: sender and receiver in one pipeline with netsim dropping packets in between.
+
+gst-launch cannot hand rtpstorage's internal storage to rtpulpfecdec, so this builds the pipeline
+with the GStreamer Python bindings, wires the storage and checks rtpulpfecdec's counters at the
+end. Exits non-zero when no packet was recovered.
+"""
+
+import argparse
+import sys
+
+import gi
+
+gi.require_version("Gst", "1.0")
+from gi.repository import GLib, Gst  # noqa: E402
+
+# Must match the sender (sender/fec.go)
+ULPFEC_PAYLOAD_TYPE = 122
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__.splitlines()[0])
+    parser.add_argument("--host", default="127.0.0.1")
+    parser.add_argument("--port", type=int, default=5000)
+    parser.add_argument("--drop", type=float, default=0.05, help="netsim drop probability")
+    parser.add_argument("--percentage", type=int, default=20, help="rtpulpfecenc percentage")
+    parser.add_argument("--duration", type=int, default=20, help="seconds to run")
+    parser.add_argument("--width", type=int, default=640)
+    parser.add_argument("--height", type=int, default=480)
+    parser.add_argument("--sink", default="autovideosink", help="video sink, fakesink for headless runs")
+    args = parser.parse_args()
+
+    Gst.init(None)
+    pipeline = Gst.parse_launch(
+        f"videotestsrc is-live=true pattern=ball ! video/x-raw,width={args.width},height={args.height},framerate=30/1 ! "
+        "x264enc tune=zerolatency speed-preset=ultrafast key-int-max=300 ! h264parse ! "
+        "rtph264pay config-interval=-1 aggregate-mode=zero-latency pt=96 ! "
+        f"rtpulpfecenc pt={ULPFEC_PAYLOAD_TYPE} percentage={args.percentage} ! "
+        f"netsim drop-probability={args.drop} ! "
+        f"udpsink host={args.host} port={args.port} sync=false async=false "
+        f'udpsrc port={args.port} caps="application/x-rtp, media=video, encoding-name=H264, clock-rate=90000, payload=96" ! '
+        "rtpstorage name=storage size-time=250000000 ! "
+        "rtpjitterbuffer do-lost=true latency=200 ! "
+        f"rtpulpfecdec name=dec pt={ULPFEC_PAYLOAD_TYPE} ! "
+        f"rtph264depay ! h264parse ! avdec_h264 ! videoconvert ! {args.sink} sync=false"
+    )
+    dec = pipeline.get_by_name("dec")
+    dec.set_property("storage", pipeline.get_by_name("storage").get_property("internal-storage"))
+
+    loop = GLib.MainLoop()
+    failed = False
+
+    def on_error(_bus, message):
+        nonlocal failed
+        err, debug = message.parse_error()
+        print(f"error from {message.src.get_name()}: {err.message} ({debug})", file=sys.stderr)
+        failed = True
+        loop.quit()
+
+    def report():
+        print(f"[ulpfec] recovered {dec.get_property('recovered')}, "
+              f"unrecovered {dec.get_property('unrecovered')}")
+        return GLib.SOURCE_CONTINUE
+
+    bus = pipeline.get_bus()
+    bus.add_signal_watch()
+    bus.connect("message::error", on_error)
+    GLib.timeout_add_seconds(5, report)
+    GLib.timeout_add_seconds(args.duration, loop.quit)
+
+    pipeline.set_state(Gst.State.PLAYING)
+    try:
+        loop.run()
+    except KeyboardInterrupt:
+        pass
+    pipeline.set_state(Gst.State.NULL)
+
+    recovered = dec.get_property("recovered")
+    unrecovered = dec.get_property("unrecovered")
+    print(f"[ulpfec] drop {args.drop:.0%}, fec {args.percentage}%: recovered {recovered}, unrecovered {unrecovered}")
+    if failed:
+        return 1
+    if recovered == 0:
+        print("[ulpfec] FAIL: rtpulpfecdec recovered no packets", file=sys.stderr)
+        return 1
+    print("[ulpfec] OK")
+    return 0
+
+
+if __name__ == "__main__":
+    sys.exit(main())